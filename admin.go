@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func getJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	statuses := []JobStatus{}
+	for _, job := range scheduler.Jobs() {
+		statuses = append(statuses, job.Status())
+	}
+
+	response := map[string]interface{}{
+		"data":     statuses,
+		"replica":  leader.ID(),
+		"isLeader": leader.IsLeader(),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
+
+func getJobByName(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	job, ok := scheduler.Get(name)
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	page, limit, offset := parsePagination(r, 20)
+
+	runs, total, err := fetchJobRuns(name, limit, offset)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Job runs query error", "err", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"data":       job.Status(),
+		"runs":       runs,
+		"pagination": paginationMeta(total, page, limit),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
+
+func getJobRun(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	row := db.QueryRow(`SELECT `+jobRunColumns+` FROM job_runs WHERE job_name = $1 AND run_id = $2`, vars["name"], vars["runID"])
+	run, err := scanJobRun(row.Scan)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Job run not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Job run query error", "err", err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(run); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
+
+func triggerJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	if _, ok := scheduler.Get(name); !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	// Jobs only run on the leader, otherwise replicas would duplicate each other's work
+	if !leader.IsLeader() {
+		http.Error(w, "This replica is not the leader, trigger the job on the leader", http.StatusServiceUnavailable)
+		return
+	}
+
+	// The run outlives the request, so it uses the scheduler's context, not the request's
+	runID, err := scheduler.Trigger(name)
+	if err == errJobRunning {
+		http.Error(w, "Job "+name+" is already running", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to start job: "+err.Error(), http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Job trigger error", "err", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "started", "run_id": runID})
+}
@@ -4,10 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
-	"math"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -60,40 +57,12 @@ func getTransactionsByTokenID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !tokenExists {
-		pinnerInfo, _ := checkPins(tokenID)
-		if pinnerInfo != nil {
-			// Token not found, returning just pinner info
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"tokenDetails":       pinnerInfo.TokenDetails,
-				"currentPinner":      pinnerInfo.CurrentPinner,
-				"currentEpochPinner": pinnerInfo.CurrentEpochPinner,
-				"isExists":           tokenExists,
-			})
-			return
-		}
-		http.Error(w, "Token not found", http.StatusNotFound)
+		// Unknown tokens are looked up in the DHT by the rate-limited syncer, never inline
+		getUnknownTokenPinners(w, r, tokenID)
 		return
 	}
 
-	page := 1
-	limit := 30 // default
-
-	queryParams := r.URL.Query()
-	if val := queryParams.Get("page"); val != "" {
-		if p, err := strconv.Atoi(val); err == nil && p > 0 {
-			page = p
-		}
-	}
-	if val := queryParams.Get("limit"); val != "" {
-		if l, err := strconv.Atoi(val); err == nil && l > 0 {
-			// if l > 100 {
-			// 	l = 100
-			// }
-			limit = l
-		}
-	}
-
-	offset := (page - 1) * limit
+	page, limit, offset := parsePagination(r, 30)
 
 	var totalCount int
 	err = db.QueryRow(`SELECT COUNT(*) FROM transactions WHERE token_id = $1`, tokenID).Scan(&totalCount)
@@ -118,45 +87,38 @@ func getTransactionsByTokenID(w http.ResponseWriter, r *http.Request) {
 	rows, err := db.Query(query, tokenID, limit, offset)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("DB query error: %v", err), http.StatusInternalServerError)
-		log.Println("DB query error:", err)
+		loggerFrom(r.Context()).Error("DB query error", "err", err)
 		return
 	}
 	defer rows.Close()
 
-	var transactions []Transaction
+	list := newJSONList(w)
 	for rows.Next() {
 		var t Transaction
 
 		err := rows.Scan(&t.TxID, &t.TokenID, pq.Array(&t.PeerID), &t.Epoch, pq.Array(&t.Quorums), &t.Timestamp)
 		if err != nil {
-			http.Error(w, "DB row scan error", http.StatusInternalServerError)
-			log.Println("Row scan error:", err)
+			list.Fail(w, r, "Row scan error", err)
+			return
+		}
+		if err := list.Add(t); err != nil {
+			list.Fail(w, r, "JSON encoding error", err)
 			return
 		}
-
-		transactions = append(transactions, t)
 	}
 
 	if err = rows.Err(); err != nil {
-		http.Error(w, "Rows iteration error", http.StatusInternalServerError)
-		log.Println("Rows iteration error:", err)
+		list.Fail(w, r, "Rows iteration error", err)
 		return
 	}
 
 	// Enhanced response with pagination metadata
-	response := map[string]interface{}{
-		"isExists": tokenExists,
-		"data":     transactions,
-		"pagination": map[string]interface{}{
-			"total":        totalCount,
-			"current_page": page,
-			"per_page":     limit,
-			"total_pages":  int(math.Ceil(float64(totalCount) / float64(limit))),
-		},
-	}
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
-		log.Println("JSON encoding error:", err)
+	err = list.Close(map[string]interface{}{
+		"isExists":   tokenExists,
+		"pagination": paginationMeta(totalCount, page, limit),
+	})
+	if err != nil {
+		list.Fail(w, r, "JSON encoding error", err)
 	}
 }
 
@@ -165,38 +127,97 @@ func getCurrentTokensByPeerID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	peerID := vars["peerID"]
 
-	page := 1
-	limit := 30 // default
+	page, limit, offset := parsePagination(r, 30)
+	var tokenOwnedCount int
 
-	queryParams := r.URL.Query()
-	if val := queryParams.Get("page"); val != "" {
-		if p, err := strconv.Atoi(val); err == nil && p > 0 {
-			page = p
+	countKey := holdingsCacheKey(peerID, "count")
+	if cached, ok := responseCache.Get(countKey); ok {
+		tokenOwnedCount = cached.(int)
+	} else {
+		err := db.QueryRow(`SELECT COUNT(*) FROM current_owners WHERE $1 = ANY(peer_ids)`, peerID).Scan(&tokenOwnedCount)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get total count of tokens: %v", err), http.StatusInternalServerError)
+			return
 		}
+		responseCache.Set(countKey, tokenOwnedCount)
 	}
-	if val := queryParams.Get("limit"); val != "" {
-		if l, err := strconv.Atoi(val); err == nil && l > 0 {
-			// if l > 100 {
-			// 	l = 100
-			// }
-			limit = l
-		}
+
+	if tokenOwnedCount == 0 {
+		http.Error(w, "No tokens found for peerID "+peerID, http.StatusNotFound)
+		return
 	}
 
-	offset := (page - 1) * limit
-	var tokenOwnedCount int
+	if limit > maxCachedPageSize {
+		streamPeerTokens(w, r, peerID, page, limit, offset, tokenOwnedCount)
+		return
+	}
 
-	err := db.QueryRow(`SELECT COUNT(*) FROM current_owners WHERE $1 = ANY(peer_ids)`, peerID).Scan(&tokenOwnedCount)
+	tokensOwned, err := fetchPeerTokensPage(peerID, limit, offset)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get total count of tokens: %v", err), http.StatusInternalServerError)
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("DB query error", "err", err)
 		return
 	}
 
-	if tokenOwnedCount == 0 {
-		http.Error(w, "No tokens found for peerID "+peerID, http.StatusNotFound)
+	// Enhanced response with pagination metadata
+	response := map[string]interface{}{
+		"data":        tokensOwned.Tokens,
+		"total_value": tokensOwned.TotalValue,
+		"pagination":  paginationMeta(tokenOwnedCount, page, limit),
+	}
+	writeCachedJSON(w, r, response)
+}
+
+// streamPeerTokens writes pages too large for the cache straight from the rows.
+func streamPeerTokens(w http.ResponseWriter, r *http.Request, peerID string, page, limit, offset, total int) {
+	list := newJSONList(w)
+	totalValue := 0.0
+	err := eachPeerToken(peerID, limit, offset, func(token CurrentOwner, tokenValue float64) error {
+		totalValue += tokenValue
+		return list.Add(token)
+	})
+	if err != nil {
+		list.Fail(w, r, "DB query error", err)
 		return
 	}
 
+	err = list.Close(map[string]interface{}{
+		"total_value": totalValue,
+		"pagination":  paginationMeta(total, page, limit),
+	})
+	if err != nil {
+		list.Fail(w, r, "JSON encoding error", err)
+	}
+}
+
+// peerTokensPage is one page of a peer's tokens and the summed value of that page.
+type peerTokensPage struct {
+	Tokens     []CurrentOwner
+	TotalValue float64
+}
+
+func fetchPeerTokensPage(peerID string, limit, offset int) (*peerTokensPage, error) {
+	key := holdingsCacheKey(peerID, fmt.Sprintf("page:%d:%d", limit, offset))
+	if cached, ok := responseCache.Get(key); ok {
+		return cached.(*peerTokensPage), nil
+	}
+
+	page := &peerTokensPage{}
+	err := eachPeerToken(peerID, limit, offset, func(token CurrentOwner, tokenValue float64) error {
+		page.TotalValue += tokenValue
+		page.Tokens = append(page.Tokens, token)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	responseCache.Set(key, page)
+	return page, nil
+}
+
+// eachPeerToken calls fn for each token of one page of a peer's current tokens, newest first.
+func eachPeerToken(peerID string, limit, offset int, fn func(token CurrentOwner, tokenValue float64) error) error {
 	query := `
 		SELECT co.token_id, co.peer_ids, co.epoch, co.quorums, co.timestamp, ti.token_value
 		FROM current_owners co
@@ -207,91 +228,45 @@ func getCurrentTokensByPeerID(w http.ResponseWriter, r *http.Request) {
 	`
 	rows, err := db.Query(query, peerID, limit, offset)
 	if err != nil {
-		http.Error(w, "DB query error", http.StatusInternalServerError)
-		log.Println("DB query error:", err)
-		return
+		return err
 	}
 	defer rows.Close()
 
-	totalValue := 0.0
-	var tokensOwned []CurrentOwner
 	for rows.Next() {
 		var token CurrentOwner
 		var tokenValue float64
 
 		err := rows.Scan(&token.TokenID, pq.Array(&token.PeerID), &token.Epoch, pq.Array(&token.Quorums), &token.Timestamp, &tokenValue)
 		if err != nil {
-			http.Error(w, "DB row scan error", http.StatusInternalServerError)
-			log.Println("Row scan error:", err)
-			return
+			return fmt.Errorf("row scan error: %w", err)
+		}
+		if err := fn(token, tokenValue); err != nil {
+			return err
 		}
-		totalValue += tokenValue
-
-		tokensOwned = append(tokensOwned, token)
 	}
-
 	if err := rows.Err(); err != nil {
-		http.Error(w, "Rows iteration error", http.StatusInternalServerError)
-		log.Println("Rows iteration error:", err)
-		return
-	}
-
-	// Enhanced response with pagination metadata
-	response := map[string]interface{}{
-		"data":        tokensOwned,
-		"total_value": totalValue,
-		"pagination": map[string]interface{}{
-			"total":        tokenOwnedCount,
-			"current_page": page,
-			"per_page":     limit,
-			"total_pages":  int(math.Ceil(float64(tokenOwnedCount) / float64(limit))),
-		},
-	}
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
-		log.Println("JSON encoding error:", err)
+		return fmt.Errorf("rows iteration error: %w", err)
 	}
+	return nil
 }
 
 func getTokenInfoByTokenID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 	vars := mux.Vars(r)
 	tokenID := vars["tokenID"]
-	var parentTokenID sql.NullString
-
-	query := `
-		SELECT token_level, token_number, token_value, parent_token_id, token_type
-		FROM token_info
-		WHERE token_id = $1
-	`
 
-	rows, err := db.Query(query, tokenID)
+	t, err := fetchTokenInfo(tokenID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "DB query error", http.StatusInternalServerError)
-		log.Println("DB query error:", err)
+		loggerFrom(r.Context()).Error("DB query error", "err", err)
 		return
 	}
-	defer rows.Close()
 
-	var t TokenInfo
-	for rows.Next() {
-		err := rows.Scan(&t.TokenLevel, &t.TokenNumber, &t.TokenValue, &parentTokenID, &t.TokenType)
-		if err != nil {
-			http.Error(w, "DB row scan error", http.StatusInternalServerError)
-			log.Println("Row scan error:", err)
-			return
-		}
-
-		if parentTokenID.Valid {
-			t.ParentTokenID = parentTokenID.String
-		} else {
-			t.ParentTokenID = "" // or "null" or "<none>" if you prefer
-		}
-
-		t.TokenID = tokenID
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(t)
+	writeCachedJSON(w, r, t)
 }
 
 // func upsertTransactionHandler(w http.ResponseWriter, r *http.Request) {
@@ -299,35 +274,19 @@ func getTokenInfoByTokenID(w http.ResponseWriter, r *http.Request) {
 // 	err := json.NewDecoder(r.Body).Decode(&t)
 // 	if err != nil {
 // 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-// 		log.Println("Decode error:", err)
+// 		loggerFrom(r.Context()).Error("Decode error", "err", err)
 // 		return
 // 	}
 // 	err = upsertTransaction(t)
 // 	if err != nil {
 // 		http.Error(w, "Upsert failed: "+err.Error(), http.StatusInternalServerError)
-// 		log.Println("Upsert error:", err)
+// 		loggerFrom(r.Context()).Error("Upsert error", "err", err)
 // 		return
 // 	}
 // 	w.Header().Set("Content-Type", "application/json")
 // 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 // }
 
-func syncLatestTokenState(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	tokenID := vars["tokenID"]
-
-	_, err := checkPins(tokenID)
-	if err != nil {
-		http.Error(w, "Sync failed: "+err.Error(), http.StatusInternalServerError)
-		log.Println("Sync error:", err)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
-}
-
 func getLatestMintedToken(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	tokenLevel, tokenNumber := tokenNum()
@@ -348,36 +307,70 @@ func tokenNum() (int, int) {
 func getCurrentTokens(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// Default pagination values
-	page := 1
-	limit := 50
+	page, limit, offset := parsePagination(r, 50)
 
-	// Parse query parameters
-	query := r.URL.Query()
-	if val := query.Get("page"); val != "" {
-		if p, err := strconv.Atoi(val); err == nil && p > 0 {
-			page = p
+	// Get total count for pagination metadata
+	var totalCount int
+	if cached, ok := responseCache.Get("current-tokens:count"); ok {
+		totalCount = cached.(int)
+	} else {
+		err := db.QueryRow("SELECT COUNT(*) FROM current_owners").Scan(&totalCount)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get total count: %v", err), http.StatusInternalServerError)
+			return
 		}
+		responseCache.Set("current-tokens:count", totalCount)
 	}
-	if val := query.Get("limit"); val != "" {
-		if l, err := strconv.Atoi(val); err == nil && l > 0 {
-			// if l > 100 {
-			// 	l = 100
-			// }
-			limit = l
+
+	// Pages too large for the cache are streamed straight from the rows
+	if limit > maxCachedPageSize {
+		list := newJSONList(w)
+		if err := eachCurrentOwner(limit, offset, func(owner CurrentOwner) error { return list.Add(owner) }); err != nil {
+			list.Fail(w, r, "Database query error", err)
+			return
+		}
+		if err := list.Close(map[string]interface{}{"pagination": paginationMeta(totalCount, page, limit)}); err != nil {
+			list.Fail(w, r, "JSON encoding error", err)
 		}
+		return
 	}
 
-	offset := (page - 1) * limit
-
-	// Get total count for pagination metadata
-	var totalCount int
-	err := db.QueryRow("SELECT COUNT(*) FROM current_owners").Scan(&totalCount)
+	results, err := fetchCurrentOwnersPage(limit, offset)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get total count: %v", err), http.StatusInternalServerError)
+		http.Error(w, "Database query error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Enhanced response with pagination metadata
+	response := map[string]interface{}{
+		"data":       results,
+		"pagination": paginationMeta(totalCount, page, limit),
+	}
+	writeCachedJSON(w, r, response)
+}
+
+// fetchCurrentOwnersPage returns one page of current_owners, newest first.
+func fetchCurrentOwnersPage(limit, offset int) ([]CurrentOwner, error) {
+	key := fmt.Sprintf("current-tokens:page:%d:%d", limit, offset)
+	if cached, ok := responseCache.Get(key); ok {
+		return cached.([]CurrentOwner), nil
+	}
+
+	var results []CurrentOwner
+	err := eachCurrentOwner(limit, offset, func(owner CurrentOwner) error {
+		results = append(results, owner)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	responseCache.Set(key, results)
+	return results, nil
+}
+
+// eachCurrentOwner calls fn for each row of one page of current_owners, newest first.
+func eachCurrentOwner(limit, offset int, fn func(CurrentOwner) error) error {
 	// Query to fetch current owners with pagination and order
 	rows, err := db.Query(`
 		SELECT token_id, peer_ids, epoch, quorums, timestamp
@@ -386,51 +379,22 @@ func getCurrentTokens(w http.ResponseWriter, r *http.Request) {
 		LIMIT $1 OFFSET $2
 	`, limit, offset)
 	if err != nil {
-		http.Error(w, "Database query error: "+err.Error(), http.StatusInternalServerError)
-		return
+		return err
 	}
 	defer rows.Close()
 
-	var results []CurrentOwner
-
 	for rows.Next() {
 		var owner CurrentOwner
 		err := rows.Scan(&owner.TokenID, pq.Array(&owner.PeerID), &owner.Epoch, pq.Array(&owner.Quorums), &owner.Timestamp)
 		if err != nil {
-			http.Error(w, "Failed to scan result: "+err.Error(), http.StatusInternalServerError)
-			return
+			return fmt.Errorf("failed to scan result: %w", err)
+		}
+		if err := fn(owner); err != nil {
+			return err
 		}
-		results = append(results, owner)
 	}
-
 	if err := rows.Err(); err != nil {
-		http.Error(w, "Row iteration error: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Enhanced response with pagination metadata
-	response := struct {
-		Data       []CurrentOwner `json:"data"`
-		Pagination struct {
-			Total       int `json:"total"`
-			CurrentPage int `json:"current_page"`
-			PerPage     int `json:"per_page"`
-			TotalPages  int `json:"total_pages"`
-		} `json:"pagination"`
-	}{
-		Data: results,
-		Pagination: struct {
-			Total       int `json:"total"`
-			CurrentPage int `json:"current_page"`
-			PerPage     int `json:"per_page"`
-			TotalPages  int `json:"total_pages"`
-		}{
-			Total:       totalCount,
-			CurrentPage: page,
-			PerPage:     limit,
-			TotalPages:  int(math.Ceil(float64(totalCount) / float64(limit))),
-		},
+		return fmt.Errorf("row iteration error: %w", err)
 	}
-
-	json.NewEncoder(w).Encode(response)
+	return nil
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var errUnauthenticated = errors.New("missing or invalid credentials")
+
+type clientKey struct{}
+
+// clientFrom returns the client name stored in ctx by requireAuth.
+func clientFrom(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
+
+// authenticate resolves the client behind a request from an X-API-Key header or an
+// "Authorization: Bearer" header carrying either an API key or a JWT.
+func authenticate(r *http.Request, c AuthConfig) (string, error) {
+	credential := r.Header.Get("X-API-Key")
+	if credential == "" {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return "", errUnauthenticated
+		}
+		credential = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if credential == "" {
+		return "", errUnauthenticated
+	}
+
+	for name, key := range c.APIKeys {
+		if key != "" && subtle.ConstantTimeCompare([]byte(credential), []byte(key)) == 1 {
+			return "key:" + name, nil
+		}
+	}
+
+	if c.JWTSecret != "" && strings.Count(credential, ".") == 2 {
+		return verifyJWT(credential, c)
+	}
+	return "", errUnauthenticated
+}
+
+// verifyJWT checks an HS256 token and returns its subject as the client name.
+func verifyJWT(raw string, c AuthConfig) (string, error) {
+	opts := []jwt.ParserOption{jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired()}
+	if c.JWTIssuer != "" {
+		opts = append(opts, jwt.WithIssuer(c.JWTIssuer))
+	}
+
+	token, err := jwt.Parse(raw, func(*jwt.Token) (interface{}, error) {
+		return []byte(c.JWTSecret), nil
+	}, opts...)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errUnauthenticated, err)
+	}
+
+	subject, err := token.Claims.GetSubject()
+	if err != nil || subject == "" {
+		return "", fmt.Errorf("%w: token has no subject", errUnauthenticated)
+	}
+	return "jwt:" + subject, nil
+}
+
+// requestClient names the client of a request for rate limiting: the authenticated client
+// when credentials are valid, the remote address otherwise.
+func requestClient(r *http.Request) (client string, anonymous bool) {
+	if client, err := authenticate(r, cfg.Auth); err == nil {
+		return client, false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host, true
+}
+
+// requireAuth rejects requests without valid credentials and stores the client name in
+// the request context for rate limiting and logs.
+func requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, err := authenticate(r, cfg.Auth)
+		if err != nil {
+			loggerFrom(r.Context()).Warn("Authentication failed", "err", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="explorer"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), clientKey{}, client)
+		ctx = withLogger(ctx, loggerFrom(ctx).With("client", client))
+		next(w, r.WithContext(ctx))
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LRUCache is a size-bounded in-process cache whose entries also expire after a TTL.
+// Values must be treated as read-only by callers, since they are shared.
+type LRUCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // Front is most recently used
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func NewLRUCache(size int, ttl time.Duration) *LRUCache {
+	return &LRUCache{size: size, ttl: ttl, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Larger pages bypass the cache and are streamed instead.
+const maxCachedPageSize = 100
+
+// responseCache holds token info, current-token pages and peer holdings. Replaced in main
+// with the configured size and TTL.
+var responseCache = NewLRUCache(10000, 30*time.Second)
+
+func (c *LRUCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		cacheRequestsTotal.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		cacheRequestsTotal.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	cacheRequestsTotal.WithLabelValues("hit").Inc()
+	return entry.value, true
+}
+
+func (c *LRUCache) Set(key string, value interface{}) {
+	if c.size <= 0 {
+		return // Caching disabled
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.value, entry.expires = value, expires
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// DeletePrefix removes every entry whose key starts with prefix.
+func (c *LRUCache) DeletePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, el := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.order.Remove(el)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// invalidateOwnership drops the cached pages affected by a token changing hands: the
+// current-tokens listing and the holdings of its previous and new owners. Other replicas
+// only see the change once their entries expire.
+func invalidateOwnership(peerSets ...[]string) {
+	responseCache.DeletePrefix("current-tokens:")
+	for _, peers := range peerSets {
+		for _, peerID := range peers {
+			responseCache.DeletePrefix(holdingsCacheKey(peerID, ""))
+		}
+	}
+}
+
+func holdingsCacheKey(peerID, suffix string) string {
+	return "holdings:" + peerID + ":" + suffix
+}
+
+// writeCachedJSON writes v with an ETag derived from the body and a Cache-Control max-age,
+// answering 304 when the client already has this version.
+func writeCachedJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+		return
+	}
+	body = append(body, '\n')
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(cfg.Cache.MaxAge))
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// etagMatches implements the weak comparison If-None-Match asks for.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -3,17 +3,15 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"time"
 
 	"decentralized-explorer-backend/ipfs"
@@ -104,45 +102,54 @@ var TokenMap = map[int]int{
 	78: 17602,
 }
 
-func checkTokenCount() {
+// errNoOwnershipChange is returned by checkPins when the token's pinners are unchanged.
+var errNoOwnershipChange = errors.New("no change in ownership")
+
+func checkTokenCount(ctx context.Context) {
+	logger := loggerFrom(ctx)
+
 	// Get current token level and number
 	currentLevel, currentNum := tokenNum()
 
-	// Query the latest token from token_info
-	var latestLevel, latestNum int
-	err := db.QueryRow(`
-        SELECT token_level, token_number 
-        FROM token_info 
-		WHERE token_type = 'RBT' 
-        ORDER BY token_level DESC, token_number DESC
-        LIMIT 1
-    `).Scan(&latestLevel, &latestNum)
-
+	latestLevel, latestNum, err := latestGeneratedToken(ctx)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// Table is empty - print True
-			genErr := generateTokenID(currentLevel, currentNum, 1, 0)
-			if genErr != nil {
-				fmt.Println(genErr)
-			}
-			return
-		}
-		log.Printf("Error querying latest token: %v", err)
+		logger.Error("Error querying latest token", "err", err)
 		return
 	}
 
 	// Check if current values are more than the latest in DB
 	if currentLevel > latestLevel || currentNum > latestNum {
-		genErr := generateTokenID(currentLevel, currentNum, latestLevel, latestNum)
+		genErr := generateTokenID(ctx, currentLevel, currentNum, latestLevel, latestNum)
 		if genErr != nil {
-			fmt.Println(genErr)
+			logger.Error("Token generation failed", "err", genErr)
 		}
 	}
 
 }
 
-func generateTokenID(currentLevel int, currentNum int, latestLevel int, latestNum int) error {
+// latestGeneratedToken returns the level and number of the newest RBT in token_info, or
+// level 1 number 0 when none have been generated, so generation starts at the first token.
+func latestGeneratedToken(ctx context.Context) (int, int, error) {
+	var latestLevel, latestNum int
+	err := db.QueryRowContext(ctx, `
+        SELECT token_level, token_number 
+        FROM token_info 
+		WHERE token_type = 'RBT' 
+        ORDER BY token_level DESC, token_number DESC
+        LIMIT 1
+    `).Scan(&latestLevel, &latestNum)
+	if err == sql.ErrNoRows {
+		return 1, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return latestLevel, latestNum, nil
+}
+
+func generateTokenID(ctx context.Context, currentLevel int, currentNum int, latestLevel int, latestNum int) error {
 	// Generate token ID based on current level and number
+	logger := loggerFrom(ctx)
 	ipfs := ipfs.GetShell()
 	tx, err := db.Begin()
 	if err != nil {
@@ -155,9 +162,15 @@ func generateTokenID(currentLevel int, currentNum int, latestLevel int, latestNu
 	num := latestNum + 1 // Start from next number
 
 	for {
+		// On shutdown keep the tokens generated so far, the next run resumes after them
+		if ctx.Err() != nil {
+			logger.Warn("Token generation cancelled, committing progress", "level", level, "number", num)
+			break
+		}
+
 		// Check if we've reached the current level and number
 		if level > currentLevel || (level == currentLevel && num > currentNum) {
-			log.Println("Token generation complete.")
+			logger.Info("Token generation complete", "level", level, "number", num-1)
 			break
 		}
 
@@ -165,24 +178,27 @@ func generateTokenID(currentLevel int, currentNum int, latestLevel int, latestNu
 		token_info := fmt.Sprintf("%d %d", level, num)
 
 		// Add to IPFS - single allocation version
-		log.Printf("Attempting to add token info '%s' to IPFS...", token_info)
+		addStart := time.Now()
 		token_id, err := ipfs.Add(strings.NewReader(token_info), ipfsnode.Pin(false), ipfsnode.OnlyHash(true))
+		ipfsAddDuration.WithLabelValues("token_id").Observe(time.Since(addStart).Seconds())
 		if err != nil {
-			log.Printf("Failed to add token %q to IPFS: %v", token_info, err)
-			return err
+			return fmt.Errorf("failed to add token %q to IPFS: %w", token_info, err)
 		}
 
-		log.Printf("IPFS add succeeded. Token ID: %s", token_id)
-		// Insert into token_info table
+		// Insert into token_info table; regenerating an overlapping range keeps existing rows
 		_, err = tx.Exec(`
 			INSERT INTO token_info 
 			(token_id, token_level, token_number, token_value, parent_token_id, token_type)
 			VALUES ($1, $2, $3, 1, NULL, 'RBT')
+			ON CONFLICT (token_id) DO NOTHING
 		`, token_id, level, num)
 		if err != nil {
 			return fmt.Errorf("failed to insert token %q: %w", token_info, err)
 		}
-		log.Printf("Added token %s to IPFS: %s", token_info, token_id)
+		logger.Debug("Generated token ID", "level", level, "number", num, "token_id", token_id)
+		tokensGeneratedTotal.Inc()
+		tokenGenerationPosition.WithLabelValues("level").Set(float64(level))
+		tokenGenerationPosition.WithLabelValues("number").Set(float64(num))
 
 		// Increment number
 		num++
@@ -196,12 +212,12 @@ func generateTokenID(currentLevel int, currentNum int, latestLevel int, latestNu
 
 				// Check if level exists in our map
 				if _, exists := TokenMap[level]; !exists {
-					log.Printf("Reached maximum level %d", level-1)
+					logger.Info("Reached maximum level", "level", level-1)
 					break
 				}
 			}
 		} else {
-			log.Printf("Invalid level %d in TokenMap", level)
+			logger.Error("Invalid level in TokenMap", "level", level)
 			break
 		}
 	}
@@ -211,50 +227,61 @@ func generateTokenID(currentLevel int, currentNum int, latestLevel int, latestNu
 	return nil
 }
 
-func checkPins(token string) (*PinnerInfo, error) {
+func checkPins(ctx context.Context, token string) (info *PinnerInfo, err error) {
+	defer func() {
+		switch {
+		case err == nil && info != nil:
+			pinChecksTotal.WithLabelValues("unknown_token").Inc()
+		case err == nil:
+			pinChecksTotal.WithLabelValues("changed").Inc()
+		case errors.Is(err, errNoOwnershipChange):
+			pinChecksTotal.WithLabelValues("unchanged").Inc()
+		default:
+			pinChecksTotal.WithLabelValues("error").Inc()
+		}
+	}()
+
+	logger := loggerFrom(ctx).With("token_id", token)
 	currentWeek := GetWeeksPassed()
 	ipfs := ipfs.GetShell()
 
 	timestamp := time.Now()
 
 	// Check pins for both tokenID and tokenEpochCID
-	currentPinner, err := GetDHTddrs(token)
+	currentPinner, err := GetDHTddrs(ctx, token)
 	if err != nil {
-		log.Printf("Failed to check pins for token %s: %v", token, err)
-		return nil, err
+		return nil, fmt.Errorf("failed to check pins for token %s: %w", token, err)
 	}
 
-	fmt.Printf("currentPinner : %v for token : %v", currentPinner, token)
-	fmt.Println()
+	logger.Debug("Found token pinners", "pinners", currentPinner)
 
 	// Check for ownership change -- update to pick the most recent pinner (TODO)
 
 	if len(currentPinner) == 0 {
-		log.Println(fmt.Errorf("no peers found for token %s", token))
 		return nil, fmt.Errorf("no peers found for token %s", token)
 	}
 
 	// Generate tokenEpoch hash (tokenID + weekEpoch)
 	tokenEpoch := fmt.Sprintf("%s-%d", token, currentWeek)
 	tokenEpochStr := bytes.NewReader([]byte(tokenEpoch))
+	addStart := time.Now()
 	tokenEpochCID, err := ipfs.Add(tokenEpochStr, ipfsnode.Pin(false), ipfsnode.OnlyHash(true))
+	ipfsAddDuration.WithLabelValues("token_epoch").Observe(time.Since(addStart).Seconds())
 
 	if err != nil {
-		log.Printf("Failed to add token epoch %q to IPFS: %v", token, err)
-		return nil, err
+		return nil, fmt.Errorf("failed to add token epoch %q to IPFS: %w", tokenEpoch, err)
 	}
 
 	// fmt.Println("tokenEpochCID : ", tokenEpochCID)
 
-	currentEpochPinner, err := GetDHTddrs(tokenEpochCID)
+	currentEpochPinner, err := GetDHTddrs(ctx, tokenEpochCID)
 	if err != nil {
-		log.Printf("Failed to check pins for token epoch %s: %v", tokenEpochCID, err)
-		return nil, err
+		return nil, fmt.Errorf("failed to check pins for token epoch %s: %w", tokenEpochCID, err)
 		// continue
 	}
 
 	// Begin database transaction
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
@@ -262,7 +289,7 @@ func checkPins(token string) (*PinnerInfo, error) {
 
 	if currentPinner != nil {
 		var exists bool
-		err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM token_info WHERE token_id = $1)", token).Scan(&exists)
+		err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM token_info WHERE token_id = $1)", token).Scan(&exists)
 		if err != nil {
 			return nil, fmt.Errorf("failed to check token existence: %w", err)
 		}
@@ -290,12 +317,15 @@ func checkPins(token string) (*PinnerInfo, error) {
 	}
 
 	var existingPeerIDs []string
-	err = tx.QueryRow(`SELECT peer_ids FROM current_owners WHERE token_id = $1`, token).
+	err = tx.QueryRowContext(ctx, `SELECT peer_ids FROM current_owners WHERE token_id = $1`, token).
 		Scan(pq.Array(&existingPeerIDs))
 
 	// If token exists in current_owners and peers haven't changed, skip update
 	if err == nil && comparePeers(currentPinner, existingPeerIDs) {
-		return nil, fmt.Errorf("no change in ownership for token %s", token)
+		if err := markTokenChecked(token, timestamp); err != nil {
+			logger.Error("Failed to record check time", "err", err)
+		}
+		return nil, fmt.Errorf("%w for token %s", errNoOwnershipChange, token)
 	}
 
 	t := Transaction{
@@ -306,14 +336,16 @@ func checkPins(token string) (*PinnerInfo, error) {
 		Timestamp: timestamp,
 	}
 
-	err = upsertTransaction(t)
+	err = upsertTransaction(ctx, t)
 	if err != nil {
 		return nil, fmt.Errorf("failed to upsert transaction: %w", err)
 	}
 	return nil, nil
 }
 
-func GetDHTddrs(cid string) ([]string, error) {
+func GetDHTddrs(ctx context.Context, cid string) (ids []string, err error) {
+	start := time.Now()
+	defer func() { observeDHTLookup(start, len(ids), err) }()
 
 	// Get the directory where the executable is located
 	exeDir, err := getAppDir()
@@ -324,9 +356,11 @@ func GetDHTddrs(cid string) ([]string, error) {
 	// Use relative paths from the executable
 	ipfsPath := filepath.Join(exeDir, "ipfs")
 	repoPath := exeDir // Repo is in the same directory
-	cmd := exec.Command(ipfsPath, "dht", "findprovs", cid)
+	cmd := exec.CommandContext(ctx, ipfsPath, "dht", "findprovs", cid)
 	cmd.Env = append(os.Environ(), "IPFS_PATH="+repoPath)
 
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, fmt.Errorf("failed to open command stdout with err : %v", err)
@@ -335,18 +369,37 @@ func GetDHTddrs(cid string) ([]string, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to start command with err : %v", err)
 	}
-	ids := make([]string, 0)
+	ids = make([]string, 0)
 	scanner := bufio.NewScanner(stdout)
 	for scanner.Scan() {
 		m := scanner.Text()
 		if strings.Contains(m, "Error") {
-			return nil, fmt.Errorf(m)
+			cmd.Process.Kill()
+			cmd.Wait()
+			return nil, errors.New(m)
 		}
 		// Collect only peer IDs (libp2p peer IDs start with "12D3Koo")
 		if strings.HasPrefix(m, "12D3Koo") {
 			ids = append(ids, m)
 		}
 	}
+	scanErr := scanner.Err()
+	if scanErr != nil {
+		// Nothing reads the pipe any more, the child could block writing to it
+		cmd.Process.Kill()
+	}
+	waitErr := cmd.Wait()
+
+	// A cancelled lookup is killed part way, its peer list must not be mistaken for a complete one
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if scanErr != nil {
+		return nil, fmt.Errorf("failed to read findprovs output: %w", scanErr)
+	}
+	if waitErr != nil {
+		return nil, fmt.Errorf("findprovs failed: %w: %s", waitErr, strings.TrimSpace(stderr.String()))
+	}
 	return ids, nil
 }
 
@@ -367,14 +420,15 @@ func comparePeers(currentPinner []string, peerID []string) bool {
 	return true
 }
 
-func syncMissingCurrentOwners() error {
+func syncMissingCurrentOwners(ctx context.Context) error {
 	query := `
 		SELECT token_id
 		FROM token_info
 		WHERE token_id NOT IN (SELECT token_id FROM current_owners)
 	`
 
-	rows, err := db.Query(query)
+	logger := loggerFrom(ctx)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
 		return fmt.Errorf("failed to query orphan tokens: %w", err)
 	}
@@ -385,7 +439,7 @@ func syncMissingCurrentOwners() error {
 	for rows.Next() {
 		var tokenID string
 		if err := rows.Scan(&tokenID); err != nil {
-			log.Println("Row scan error:", err)
+			logger.Error("Row scan error", "err", err)
 			continue
 		}
 		missingTokens = append(missingTokens, tokenID)
@@ -396,19 +450,23 @@ func syncMissingCurrentOwners() error {
 	}
 
 	if len(missingTokens) == 0 {
-		log.Println("All tokens in token_info have current_owners")
+		logger.Info("All tokens in token_info have current_owners")
 		return nil
 	}
 
-	log.Printf("Found %d token(s) missing from current_owners. Checking pins...\n", len(missingTokens))
+	logger.Info("Found tokens missing from current_owners, checking pins", "count", len(missingTokens))
 	for _, tokenID := range missingTokens {
-		log.Printf("Checking pin for token_id: %s", tokenID)
-		if _, err := checkPins(tokenID); err != nil {
-			log.Printf("checkPins failed for %s: %v", tokenID, err)
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("sync of missing current_owners interrupted: %w", err)
+		}
+
+		logger.Debug("Checking pin", "token_id", tokenID)
+		if _, err := checkPins(ctx, tokenID); err != nil {
+			logger.Warn("checkPins failed", "token_id", tokenID, "err", err)
 		}
 	}
 
-	log.Println("syncMissingCurrentOwners complete")
+	logger.Info("syncMissingCurrentOwners complete")
 	return nil
 }
 
@@ -441,92 +499,3 @@ func syncMissingCurrentOwners() error {
 // 		<-ticker.C
 // 	}
 // }
-
-func startDailyPinCheck() {
-	// Add jitter (±1 hour) to avoid thundering herd
-	jitter := time.Duration(rand.Int63n(int64(2*time.Hour))) - time.Hour
-	ticker := time.NewTicker(24*time.Hour + jitter)
-	defer ticker.Stop()
-
-	for {
-		startTime := time.Now()
-		log.Println("Daily pin check started...")
-
-		// Semaphore for controlling concurrency (e.g., 10 concurrent checks)
-		sem := make(chan struct{}, 10)
-		var wg sync.WaitGroup
-		var processedCount int64
-		var errorCount int64
-
-		// Process in batches of 1000 tokens
-		batchSize := 1000
-		offset := 0
-
-		for {
-			// Get batch of tokens
-			rows, err := db.Query(
-				`SELECT token_id FROM current_owners ORDER BY token_id LIMIT $1 OFFSET $2`,
-				batchSize, offset,
-			)
-			if err != nil {
-				log.Printf("DB batch query error (offset %d): %v", offset, err)
-				break
-			}
-
-			var batchCount int
-			for rows.Next() {
-				var tokenID string
-				if err := rows.Scan(&tokenID); err != nil {
-					log.Printf("Scan error in batch (offset %d): %v", offset, err)
-					atomic.AddInt64(&errorCount, 1)
-					continue
-				}
-
-				wg.Add(1)
-				sem <- struct{}{} // Acquire semaphore slot
-				go func(t string) {
-					defer func() {
-						<-sem // Release semaphore slot
-						wg.Done()
-
-						// Recover from any panic in checkPins
-						if r := recover(); r != nil {
-							log.Printf("Recovered from panic in token %s: %v", t, r)
-							atomic.AddInt64(&errorCount, 1)
-						}
-					}()
-
-					if _, err := checkPins(t); err != nil {
-						log.Printf("Pin check failed for %s: %v", t, err)
-						atomic.AddInt64(&errorCount, 1)
-					}
-					atomic.AddInt64(&processedCount, 1)
-				}(tokenID)
-				batchCount++
-			}
-			rows.Close()
-
-			if batchCount == 0 {
-				break // No more tokens
-			}
-			offset += batchCount
-		}
-
-		// Wait for all goroutines to finish
-		wg.Wait()
-		close(sem)
-
-		// Log completion
-		duration := time.Since(startTime)
-		log.Printf(
-			"Daily pin check completed. Tokens: %d, Errors: %d, Duration: %v",
-			processedCount, errorCount, duration,
-		)
-
-		// Adjust next tick to ensure full 24h between completions
-		if duration < 24*time.Hour {
-			time.Sleep(24*time.Hour - duration)
-		}
-		<-ticker.C // Wait for next tick (with jitter)
-	}
-}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"decentralized-explorer-backend/ipfs"
+
+	"github.com/lib/pq"
+)
+
+// command is one subcommand of the explorer binary. main connects the database and makes
+// sure an IPFS daemon is reachable before run when the command needs them.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	db      bool
+	ipfs    bool
+	run     func(ctx context.Context, args []string) error
+}
+
+// commands in the order the usage lists them. Without a subcommand the binary serves.
+var commands = []*command{
+	{name: "serve", usage: "[-addr addr]", summary: "run the API server and background jobs (default)", db: true, ipfs: true, run: runServe},
+	{name: "ipfs-init", summary: "initialize and configure the IPFS repo next to the executable", run: runIPFSInit},
+	{name: "migrate", summary: "create the database and apply schema changes", db: true, run: runMigrate},
+	{name: "generate-tokens", usage: "[-from-level n -from-number n] [-to-level n -to-number n]", summary: "generate RBT token IDs in a level/number range", db: true, ipfs: true, run: runGenerateTokens},
+	{name: "check-token", usage: "<token-id>", summary: "look up a token's pinners in the DHT and record its owner", db: true, ipfs: true, run: runCheckToken},
+	{name: "sync-missing", summary: "check pins of every token without a current owner", db: true, ipfs: true, run: runSyncMissing},
+	{name: "export", usage: "-table name [-format csv|ndjson|parquet] [filters]", summary: "dump a table to chunked files", db: true, run: runExportCommand},
+	{name: "stats", usage: "[-top n] [-refresh]", summary: "print holder and supply statistics as JSON", db: true, run: runStats},
+	// Publishing or fetching a snapshot uses the API of an already running node
+	{name: "create-snapshot", usage: "[-out dir] [-publish]", summary: "write a signed snapshot, optionally adding it to IPFS", db: true, run: runCreateSnapshotCommand},
+	{name: "import-snapshot", usage: "[-replace] [-allow-unsigned] <dir or CID>", summary: "verify and load a snapshot", db: true, run: runImportSnapshotCommand},
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [-config path] <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-16s %s\n", c.name, c.summary)
+		if c.usage != "" {
+			fmt.Fprintf(out, "  %-16s   %s %s\n", "", c.name, c.usage)
+		}
+	}
+	fmt.Fprintf(out, "\nGlobal flags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
+
+func wantsHelp(args []string) bool {
+	for _, arg := range args {
+		switch arg {
+		case "-h", "-help", "--help":
+			return true
+		}
+	}
+	return false
+}
+
+// ensureIPFS reuses a daemon already serving the repo next to the executable, or
+// initializes the repo and starts one. The returned function stops a daemon started here.
+func ensureIPFS(appDir string) (func(), error) {
+	if ipfs.DaemonRunning(appDir) {
+		slog.Info("Using running IPFS daemon")
+		return func() {}, nil
+	}
+
+	if err := ipfs.NewIPFSSetup(appDir); err != nil {
+		return nil, fmt.Errorf("IPFS setup failed: %w", err)
+	}
+	daemonCmd, err := ipfs.StartDaemon(appDir)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		slog.Info("Stopping IPFS daemon")
+		if err := ipfs.StopDaemon(daemonCmd, 15*time.Second); err != nil {
+			slog.Error("IPFS daemon stop error", "err", err)
+		}
+	}, nil
+}
+
+func runIPFSInit(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("ipfs-init", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	appDir, err := getAppDir()
+	if err != nil {
+		return err
+	}
+	if err := ipfs.NewIPFSSetup(appDir); err != nil {
+		return err
+	}
+	slog.Info("IPFS repo ready", "path", appDir)
+	return nil
+}
+
+// runMigrate only reports the result: main has already connected, which creates the
+// database and applies createSchema.
+func runMigrate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := backfillTransfers(); err != nil {
+		return err
+	}
+
+	var version int
+	if err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	slog.Info("Database schema up to date", "version", version)
+	return nil
+}
+
+func runGenerateTokens(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("generate-tokens", flag.ContinueOnError)
+	fromLevel := fs.Int("from-level", 0, "level of the first token (default: after the latest generated token)")
+	fromNumber := fs.Int("from-number", 0, "number of the first token within from-level")
+	toLevel := fs.Int("to-level", 0, "level of the last token (default: the current network position)")
+	toNumber := fs.Int("to-number", 0, "number of the last token within to-level")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *fromNumber != 0 && *fromLevel == 0 {
+		return errors.New("-from-number requires -from-level")
+	}
+	if *toNumber != 0 && *toLevel == 0 {
+		return errors.New("-to-number requires -to-level")
+	}
+
+	// generateTokenID starts after the position it is given
+	startLevel, startNumber := *fromLevel, *fromNumber-1
+	if *fromLevel == 0 {
+		var err error
+		if startLevel, startNumber, err = latestGeneratedToken(ctx); err != nil {
+			return err
+		}
+	} else if max, ok := TokenMap[*fromLevel]; !ok || *fromNumber < 1 || *fromNumber > max {
+		return fmt.Errorf("invalid start level %d number %d", *fromLevel, *fromNumber)
+	}
+
+	endLevel, endNumber := *toLevel, *toNumber
+	if *toLevel == 0 {
+		endLevel, endNumber = tokenNum()
+	} else if max, ok := TokenMap[*toLevel]; !ok || *toNumber < 1 || *toNumber > max {
+		return fmt.Errorf("invalid end level %d number %d", *toLevel, *toNumber)
+	}
+
+	if *fromLevel != 0 && (startLevel > endLevel || startLevel == endLevel && startNumber >= endNumber) {
+		return fmt.Errorf("start level %d number %d is after end level %d number %d", *fromLevel, *fromNumber, endLevel, endNumber)
+	}
+
+	slog.Info("Generating tokens", "from_level", startLevel, "after_number", startNumber, "to_level", endLevel, "to_number", endNumber)
+	return generateTokenID(ctx, endLevel, endNumber, startLevel, startNumber)
+}
+
+// checkTokenResult is what check-token prints.
+type checkTokenResult struct {
+	TokenID string        `json:"token_id"`
+	Outcome string        `json:"outcome"` // changed, unchanged or unknown_token
+	Owner   *CurrentOwner `json:"owner"`
+	Pinners *PinnerInfo   `json:"pinners,omitempty"` // Only for tokens missing from token_info
+}
+
+func runCheckToken(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("check-token", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: check-token <token-id>")
+	}
+	tokenID := fs.Arg(0)
+
+	result := checkTokenResult{TokenID: tokenID, Outcome: "changed"}
+	info, err := checkPins(ctx, tokenID)
+	switch {
+	case errors.Is(err, errNoOwnershipChange):
+		result.Outcome = "unchanged"
+	case err != nil:
+		return err
+	case info != nil:
+		result.Outcome, result.Pinners = "unknown_token", info
+	}
+
+	var owner CurrentOwner
+	err = db.QueryRowContext(ctx, `
+		SELECT token_id, peer_ids, epoch, quorums, timestamp
+		FROM current_owners WHERE token_id = $1
+	`, tokenID).Scan(&owner.TokenID, pq.Array(&owner.PeerID), &owner.Epoch, pq.Array(&owner.Quorums), &owner.Timestamp)
+	if err == nil {
+		result.Owner = &owner
+	} else if err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read owner: %w", err)
+	}
+
+	return printJSON(result)
+}
+
+func runSyncMissing(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("sync-missing", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return syncMissingCurrentOwners(ctx)
+}
+
+func runStats(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	top := fs.Int("top", 20, "number of top holders to list")
+	refresh := fs.Bool("refresh", false, "refresh the holder balances first, as the stats-refresh job does")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *refresh {
+		if err := refreshHolderStats(); err != nil {
+			return err
+		}
+	}
+	holders, err := computeHolderStats(*top)
+	if err != nil {
+		return err
+	}
+	supply, err := computeSupplyStats()
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{"holders": holders, "supply": supply})
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// JobConfig controls the schedule of one background job.
+type JobConfig struct {
+	Schedule string `json:"schedule"` // Standard 5-field cron expression
+	Enabled  bool   `json:"enabled"`
+}
+
+// AuthConfig lists the credentials accepted by authenticated endpoints. A request
+// authenticates with one of the API keys or with an HS256 JWT signed by JWTSecret.
+type AuthConfig struct {
+	APIKeys   map[string]string `json:"api_keys"`   // Client name -> key
+	JWTSecret string            `json:"jwt_secret"` // Empty disables JWT auth
+	JWTIssuer string            `json:"jwt_issuer"` // Required "iss" claim, if set
+}
+
+// RateLimit is a token bucket refilled at PerMinute requests per minute.
+type RateLimit struct {
+	PerMinute float64 `json:"per_minute"`
+	Burst     int     `json:"burst"`
+}
+
+// SyncConfig controls on-demand token syncs through POST /synctokenstate and lookups of
+// unknown tokens through /token-updates.
+type SyncConfig struct {
+	ClientLimit    RateLimit `json:"client_limit"`    // Per client, anonymous ones by remote address
+	GlobalLimit    RateLimit `json:"global_limit"`    // Across authenticated clients, counts only new DHT lookups
+	AnonymousLimit RateLimit `json:"anonymous_limit"` // Across anonymous clients, kept apart from global_limit
+	Concurrency    int       `json:"concurrency"`
+}
+
+// TLSConfig enables HTTPS. The certificate, key and client CA are reloaded when they
+// change on disk or on SIGHUP.
+type TLSConfig struct {
+	Enabled      bool   `json:"enabled"`
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file"` // Requires client certificates for /admin endpoints
+	RedirectAddr string `json:"redirect_addr"`  // Plain HTTP listener redirecting to HTTPS, e.g. ":80"
+}
+
+// ServerConfig controls the API listener.
+type ServerConfig struct {
+	Addr                 string    `json:"addr"`
+	MaxPageSize          int       `json:"max_page_size"` // Upper bound for the limit query parameter
+	TLS                  TLSConfig `json:"tls"`
+	ShutdownDrainSeconds int       `json:"shutdown_drain_seconds"` // How long /readyz fails before the listener closes
+}
+
+// CORSConfig controls which browser origins may call the API. "*" allows any origin,
+// but cannot be combined with AllowCredentials.
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods"`
+	AllowedHeaders   []string `json:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAge           int      `json:"max_age"` // Seconds browsers may cache a preflight response
+}
+
+// SecurityConfig holds the security headers and request limits applied to every response.
+type SecurityConfig struct {
+	HSTSMaxAge            int    `json:"hsts_max_age"` // Seconds, 0 disables HSTS
+	ContentSecurityPolicy string `json:"content_security_policy"`
+	MaxBodyBytes          int64  `json:"max_body_bytes"` // 0 disables the limit
+}
+
+// CacheConfig sizes the in-process response cache and the Cache-Control max-age of
+// cached endpoints. A size of 0 disables the cache.
+type CacheConfig struct {
+	Size       int `json:"size"`
+	TTLSeconds int `json:"ttl_seconds"`
+	MaxAge     int `json:"max_age"`
+}
+
+// SnapshotConfig controls signed snapshots. SigningKeyFile is an ed25519 private key in
+// PKCS#8 PEM form; TrustedKeys are the base64 public keys whose snapshots import-snapshot
+// accepts.
+type SnapshotConfig struct {
+	SigningKeyFile string   `json:"signing_key_file"`
+	TrustedKeys    []string `json:"trusted_keys"`
+}
+
+// PublishConfig controls the state-publish job. An empty IPNSKey publishes snapshots
+// without moving an IPNS name.
+type PublishConfig struct {
+	IPNSKey         string `json:"ipns_key"`           // Name of the IPFS key the IPNS pointer is published under
+	ShardSize       int    `json:"shard_size"`         // Tokens per DAG-JSON shard node
+	MaxEpochsPerRun int    `json:"max_epochs_per_run"` // Bounds catching up on missed epochs
+}
+
+// ReconcilePeer is another explorer to compare ownership with, either through its
+// /export/current_owners API or through the epoch snapshots it publishes under IPNSName.
+type ReconcilePeer struct {
+	Name     string `json:"name"`
+	APIURL   string `json:"api_url"`
+	APIKey   string `json:"api_key"` // Sent as X-API-Key, since exports require authentication
+	IPNSName string `json:"ipns_name"`
+}
+
+// ReconcileConfig controls the reconcile job. With Recheck set, tokens a peer disagrees
+// on are re-checked against the DHT, up to MaxRechecks per peer and run.
+type ReconcileConfig struct {
+	Peers          []ReconcilePeer `json:"peers"`
+	Recheck        bool            `json:"recheck"`
+	MaxRechecks    int             `json:"max_rechecks"`
+	MaxStoredDiffs int             `json:"max_stored_diffs"` // Disagreements kept per run; counts include all
+}
+
+// Config holds the explorer settings loaded from config.json next to the executable.
+// Missing fields keep their defaults.
+type Config struct {
+	Server    ServerConfig         `json:"server"`
+	Log       LogConfig            `json:"log"`
+	CORS      CORSConfig           `json:"cors"`
+	Security  SecurityConfig       `json:"security"`
+	Auth      AuthConfig           `json:"auth"`
+	Sync      SyncConfig           `json:"sync"`
+	Cache     CacheConfig          `json:"cache"`
+	Snapshot  SnapshotConfig       `json:"snapshot"`
+	Publish   PublishConfig        `json:"publish"`
+	Reconcile ReconcileConfig      `json:"reconcile"`
+	Jobs      map[string]JobConfig `json:"jobs"`
+}
+
+var cfg = defaultConfig()
+
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{Addr: ":3000", MaxPageSize: 1000, ShutdownDrainSeconds: 5},
+		Log:    LogConfig{Level: "info", Format: "text"},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Content-Type", "Authorization", "X-API-Key", "X-Request-ID"},
+			ExposedHeaders: []string{"X-Request-ID", "Location", "Retry-After"},
+			MaxAge:         600,
+		},
+		Security: SecurityConfig{
+			HSTSMaxAge: 31536000,
+			// The API only serves JSON; a docs UI needs its script and style sources added
+			ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'",
+			MaxBodyBytes:          1 << 20,
+		},
+		Sync: SyncConfig{
+			ClientLimit:    RateLimit{PerMinute: 6, Burst: 3},
+			GlobalLimit:    RateLimit{PerMinute: 60, Burst: 10},
+			AnonymousLimit: RateLimit{PerMinute: 12, Burst: 4},
+			Concurrency:    4,
+		},
+		Cache:     CacheConfig{Size: 10000, TTLSeconds: 30, MaxAge: 15},
+		Publish:   PublishConfig{IPNSKey: "explorer-state", ShardSize: 1000, MaxEpochsPerRun: 4},
+		Reconcile: ReconcileConfig{MaxRechecks: 100, MaxStoredDiffs: 10000},
+		Jobs: map[string]JobConfig{
+			"weekly-sync":     {Schedule: "0 0 * * 0", Enabled: true},
+			"pin-check-queue": {Schedule: "*/10 * * * *", Enabled: true},
+			"stats-refresh":   {Schedule: "*/15 * * * *", Enabled: true},
+			"supply-snapshot": {Schedule: "5 0 * * *", Enabled: true},
+			"state-publish":   {Schedule: "30 1 * * *", Enabled: true},
+			"reconcile":       {Schedule: "0 3 * * *", Enabled: true},
+		},
+	}
+}
+
+// loadConfig reads the config file at path on top of the defaults. A missing file is not
+// an error.
+func loadConfig(path string) (*Config, error) {
+	c := defaultConfig()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+
+	// Decoding on top of the defaults keeps fields the file does not mention; jobs are
+	// merged one by one since map values would be replaced whole
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	if err := mergeJobs(data, c); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	// Keep secrets out of the config file if preferred
+	if secret := os.Getenv("EXPLORER_JWT_SECRET"); secret != "" {
+		c.Auth.JWTSecret = secret
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	return c, nil
+}
+
+// renamedJobs maps job names used by earlier releases to their current names.
+var renamedJobs = map[string]string{
+	"daily-pin-check": "pin-check-queue",
+}
+
+// mergeJobs decodes each job in the file on top of that job's defaults. json.Unmarshal
+// replaces map values whole, so a job that only sets its schedule would otherwise come out
+// disabled. Settings under an old job name move to the current one, so existing config
+// files keep their schedule instead of silently falling back to the default.
+func mergeJobs(data []byte, c *Config) error {
+	var file struct {
+		Jobs map[string]json.RawMessage `json:"jobs"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	defaults := defaultConfig().Jobs
+	c.Jobs = defaultConfig().Jobs
+	for name, raw := range file.Jobs {
+		if current, ok := renamedJobs[name]; ok {
+			if _, both := file.Jobs[current]; both {
+				return fmt.Errorf("jobs: %s was renamed to %s, configure only %s", name, current, current)
+			}
+			name = current
+		}
+		jc := defaults[name]
+		if err := json.Unmarshal(raw, &jc); err != nil {
+			return fmt.Errorf("jobs.%s: %w", name, err)
+		}
+		c.Jobs[name] = jc
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	if c.Server.MaxPageSize < 1 {
+		return fmt.Errorf("server.max_page_size must be at least 1")
+	}
+	if c.Server.ShutdownDrainSeconds < 0 {
+		return fmt.Errorf("server.shutdown_drain_seconds cannot be negative")
+	}
+	if c.Publish.ShardSize < 1 {
+		return fmt.Errorf("publish.shard_size must be at least 1")
+	}
+	for i, peer := range c.Reconcile.Peers {
+		if peer.Name == "" || (peer.APIURL == "") == (peer.IPNSName == "") {
+			return fmt.Errorf("reconcile.peers[%d]: a name and exactly one of api_url or ipns_name are required", i)
+		}
+	}
+	if t := c.Server.TLS; t.Enabled && (t.CertFile == "" || t.KeyFile == "") {
+		return fmt.Errorf("server.tls: cert_file and key_file are required when TLS is enabled")
+	}
+	if c.CORS.AllowCredentials {
+		for _, origin := range c.CORS.AllowedOrigins {
+			if origin == "*" {
+				return fmt.Errorf("cors: allowed_origins cannot contain \"*\" when allow_credentials is set")
+			}
+		}
+	}
+	return nil
+}
+
+// configPath picks the config file: the -config flag, then the EXPLORER_CONFIG environment
+// variable, then config.json next to the executable.
+func configPath(flagPath, appDir string) string {
+	if flagPath != "" {
+		return flagPath
+	}
+	if env := os.Getenv("EXPLORER_CONFIG"); env != "" {
+		return env
+	}
+	return filepath.Join(appDir, "config.json")
+}
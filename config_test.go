@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigJobs(t *testing.T) {
+	defaults := defaultConfig().Jobs
+
+	tests := []struct {
+		name string
+		body string
+		job  string
+		want JobConfig
+	}{
+		{
+			name: "schedule only keeps the job enabled",
+			body: `{"jobs":{"stats-refresh":{"schedule":"*/5 * * * *"}}}`,
+			job:  "stats-refresh",
+			want: JobConfig{Schedule: "*/5 * * * *", Enabled: true},
+		},
+		{
+			name: "enabled only keeps the default schedule",
+			body: `{"jobs":{"reconcile":{"enabled":false}}}`,
+			job:  "reconcile",
+			want: JobConfig{Schedule: defaults["reconcile"].Schedule, Enabled: false},
+		},
+		{
+			name: "other jobs keep their defaults",
+			body: `{"jobs":{"stats-refresh":{"schedule":"*/5 * * * *"}}}`,
+			job:  "weekly-sync",
+			want: defaults["weekly-sync"],
+		},
+		{
+			name: "old job name moves to the current one",
+			body: `{"jobs":{"daily-pin-check":{"schedule":"0 4 * * *"}}}`,
+			job:  "pin-check-queue",
+			want: JobConfig{Schedule: "0 4 * * *", Enabled: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := loadConfig(writeConfig(t, tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Jobs[tt.job]; got != tt.want {
+				t.Errorf("jobs[%s] = %+v, want %+v", tt.job, got, tt.want)
+			}
+			if _, ok := c.Jobs["daily-pin-check"]; ok {
+				t.Error("old job name daily-pin-check was kept")
+			}
+		})
+	}
+}
+
+func TestLoadConfigRenamedJobConflict(t *testing.T) {
+	_, err := loadConfig(writeConfig(t, `{"jobs":{"daily-pin-check":{},"pin-check-queue":{}}}`))
+	if err == nil {
+		t.Fatal("expected an error when both the old and the new job name are configured")
+	}
+}
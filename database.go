@@ -1,9 +1,10 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/lib/pq"
@@ -18,6 +19,9 @@ const (
 	dbName     = "decentralized_explorer"
 )
 
+// schemaVersion is bumped whenever createSchema changes the database layout.
+const schemaVersion = 8
+
 var db *sql.DB
 
 func setupDatabase() error {
@@ -32,14 +36,14 @@ func setupDatabase() error {
 		// Verify connection
 		if err = dbConn.Ping(); err == nil {
 			db = dbConn
-			log.Println("Connected to existing database")
+			slog.Info("Connected to existing database", "host", dbHost, "database", dbName)
 			return createSchema(db)
 		}
 		dbConn.Close()
 	}
 
 	// If we get here, we need to create the database
-	log.Println("Database does not exist, creating it...")
+	slog.Info("Database does not exist, creating it", "database", dbName)
 
 	// Connect to postgres database to create our target db
 	adminConnStr := fmt.Sprintf(
@@ -69,7 +73,7 @@ func setupDatabase() error {
 	}
 
 	db = dbConn
-	log.Println("Database created and connected successfully")
+	slog.Info("Database created and connected", "database", dbName)
 	return createSchema(db)
 }
 
@@ -121,6 +125,115 @@ func createSchema(db *sql.DB) (retErr error) {
 			timestamp TIMESTAMPTZ NOT NULL DEFAULT NOW(),  -- Added timestamp field
 			FOREIGN KEY (token_id) REFERENCES token_info(token_id)
 		);
+
+		-- Last time checkPins looked at the token, even when ownership did not change
+		ALTER TABLE current_owners ADD COLUMN IF NOT EXISTS last_checked TIMESTAMPTZ;
+
+		-- History of background job executions, used by the scheduler and /admin/jobs
+		CREATE TABLE IF NOT EXISTS job_runs (
+			run_id BIGSERIAL PRIMARY KEY,
+			job_name TEXT NOT NULL,
+			trigger TEXT NOT NULL,
+			status TEXT NOT NULL,
+			started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			finished_at TIMESTAMPTZ,
+			processed BIGINT NOT NULL DEFAULT 0,
+			errors BIGINT NOT NULL DEFAULT 0,
+			error TEXT
+		);
+		-- Replica that ran the job, so a new leader only fails runs of replicas that are gone
+		ALTER TABLE job_runs ADD COLUMN IF NOT EXISTS replica TEXT;
+
+		-- Pin-check queue: which token to check next and when
+		CREATE TABLE IF NOT EXISTS check_queue (
+			token_id TEXT PRIMARY KEY,
+			priority INT NOT NULL DEFAULT 0,
+			reason TEXT NOT NULL,  -- new, moved, stable, requested, watched or error
+			watched BOOLEAN NOT NULL DEFAULT FALSE,
+			stable_checks INT NOT NULL DEFAULT 0,  -- Consecutive checks without an ownership change
+			next_check TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			FOREIGN KEY (token_id) REFERENCES token_info(token_id)
+		);
+
+		-- Daily supply statistics kept for charts
+		CREATE TABLE IF NOT EXISTS supply_snapshots (
+			snapshot_date DATE PRIMARY KEY,
+			total_minted BIGINT NOT NULL,
+			owned BIGINT NOT NULL,
+			supply JSONB NOT NULL,  -- Full SupplyStats including per-level breakdown
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		-- Explicit ownership changes derived from consecutive transactions rows
+		CREATE TABLE IF NOT EXISTS transfers (
+			transfer_id SERIAL PRIMARY KEY,
+			tx_id INT NOT NULL UNIQUE,
+			token_id TEXT NOT NULL,
+			from_peer_ids TEXT[] NOT NULL,  -- Empty for the first sighting of a token
+			to_peer_ids TEXT[] NOT NULL,
+			epoch INT NOT NULL,
+			detected_at TIMESTAMPTZ NOT NULL,
+			FOREIGN KEY (tx_id) REFERENCES transactions(tx_id),
+			FOREIGN KEY (token_id) REFERENCES token_info(token_id)
+		);
+
+		-- Highest tx_id backfillTransfers has scanned, so startups skip the scan when nothing is new
+		CREATE TABLE IF NOT EXISTS transfers_backfill (
+			id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+			max_tx_id INT NOT NULL
+		);
+
+		-- On-demand syncs requested through POST /synctokenstate, polled by request_id
+		CREATE TABLE IF NOT EXISTS sync_requests (
+			request_id BIGSERIAL PRIMARY KEY,
+			token_id TEXT NOT NULL,
+			client TEXT NOT NULL,
+			status TEXT NOT NULL,  -- queued, running, succeeded or failed
+			outcome TEXT,  -- changed, unchanged or unknown_token
+			result JSONB,  -- PinnerInfo of tokens missing from token_info
+			error TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			started_at TIMESTAMPTZ,
+			finished_at TIMESTAMPTZ
+		);
+
+		-- Per-epoch ownership snapshots published to IPFS by the state-publish job
+		CREATE TABLE IF NOT EXISTS published_epochs (
+			epoch INT PRIMARY KEY,
+			cid TEXT NOT NULL,  -- Root DAG-JSON node of the snapshot
+			tokens BIGINT NOT NULL,
+			shards INT NOT NULL,
+			ipns_name TEXT,  -- Set once the IPNS pointer has been moved to this snapshot
+			published_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		-- Comparisons of current_owners against peer explorers and the tokens they disagree on
+		CREATE TABLE IF NOT EXISTS reconcile_runs (
+			run_id BIGSERIAL PRIMARY KEY,
+			peer TEXT NOT NULL,
+			source TEXT,  -- Export URL or snapshot CID
+			status TEXT NOT NULL,  -- running, succeeded or failed
+			compared BIGINT NOT NULL DEFAULT 0,
+			mismatches BIGINT NOT NULL DEFAULT 0,
+			missing_local BIGINT NOT NULL DEFAULT 0,
+			missing_remote BIGINT NOT NULL DEFAULT 0,
+			rechecked INT NOT NULL DEFAULT 0,
+			resolved INT NOT NULL DEFAULT 0,
+			error TEXT,
+			started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			finished_at TIMESTAMPTZ
+		);
+
+		CREATE TABLE IF NOT EXISTS reconcile_diffs (
+			run_id BIGINT NOT NULL REFERENCES reconcile_runs(run_id) ON DELETE CASCADE,
+			token_id TEXT NOT NULL,
+			kind TEXT NOT NULL,  -- mismatch, missing_local or missing_remote
+			local_peer_ids TEXT[] NOT NULL,
+			remote_peer_ids TEXT[] NOT NULL,
+			recheck TEXT,  -- checkPins outcome: changed, unchanged or failed
+			resolved BOOLEAN NOT NULL DEFAULT FALSE,
+			PRIMARY KEY (run_id, token_id)
+		);
 	`)
 	if retErr != nil {
 		return fmt.Errorf("failed to create tables: %w", retErr)
@@ -131,29 +244,93 @@ func createSchema(db *sql.DB) (retErr error) {
 	    CREATE INDEX IF NOT EXISTS idx_token_info ON token_info(token_id);
 		CREATE INDEX IF NOT EXISTS idx_token_id ON transactions (token_id);
 		CREATE INDEX IF NOT EXISTS idx_current_owners_timestamp ON current_owners(timestamp DESC);
+		CREATE INDEX IF NOT EXISTS idx_token_info_parent ON token_info(parent_token_id);
 		--CREATE INDEX IF NOT EXISTS idx_peer_id ON transactions (peer_ids);
-		-- CREATE INDEX IF NOT EXISTS idx_epoch ON transactions (epoch);
+		CREATE INDEX IF NOT EXISTS idx_epoch ON transactions (epoch);
+		CREATE INDEX IF NOT EXISTS idx_transactions_token_timestamp ON transactions (token_id, timestamp DESC, tx_id DESC);
+		CREATE INDEX IF NOT EXISTS idx_transactions_timestamp ON transactions (timestamp);
 		--CREATE INDEX IF NOT EXISTS idx_current_owner_peer_id ON current_owners (peer_ids);
 
 		-- GIN indexes for array operations (enables efficient array queries)
 		CREATE INDEX IF NOT EXISTS idx_transactions_peer_ids ON transactions USING GIN(peer_ids);
 		CREATE INDEX IF NOT EXISTS idx_current_owner_peer_ids ON current_owners USING GIN(peer_ids);
+		CREATE INDEX IF NOT EXISTS idx_transactions_quorums ON transactions USING GIN(quorums);
+		CREATE INDEX IF NOT EXISTS idx_transfers_token_id ON transfers (token_id, detected_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_transfers_epoch ON transfers (epoch);
+		CREATE INDEX IF NOT EXISTS idx_job_runs_job_started ON job_runs (job_name, started_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_check_queue_due ON check_queue (priority DESC, next_check);
+		CREATE INDEX IF NOT EXISTS idx_transfers_from_peer_ids ON transfers USING GIN(from_peer_ids);
+		CREATE INDEX IF NOT EXISTS idx_transfers_to_peer_ids ON transfers USING GIN(to_peer_ids);
+		-- Byte-order keys for exports, epoch publishing and reconciliation, which page and
+		-- merge by token_id COLLATE "C" so every replica and peer sorts the same way
+		CREATE INDEX IF NOT EXISTS idx_token_info_token_id_c ON token_info (token_id COLLATE "C");
+		CREATE INDEX IF NOT EXISTS idx_current_owners_token_id_c ON current_owners (token_id COLLATE "C");
+		CREATE INDEX IF NOT EXISTS idx_transactions_token_id_c ON transactions (token_id COLLATE "C", timestamp DESC, tx_id DESC);
+		-- At most one pending sync per token, so concurrent requests coalesce
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_sync_requests_pending ON sync_requests (token_id) WHERE status IN ('queued', 'running');
+		CREATE INDEX IF NOT EXISTS idx_sync_requests_finished ON sync_requests (finished_at);
 	`)
 	if retErr != nil {
 		return fmt.Errorf("failed to create indexes: %w", retErr)
 	}
 
+	// Materialized views backing /stats/holders, refreshed by startStatsRefresh
+	_, retErr = tx.Exec(`
+		CREATE MATERIALIZED VIEW IF NOT EXISTS holder_balances AS
+			SELECT peer_id, COUNT(*) AS token_count, COALESCE(SUM(ti.token_value), 0) AS total_value
+			FROM current_owners co
+			JOIN token_info ti ON co.token_id = ti.token_id
+			CROSS JOIN LATERAL unnest(co.peer_ids) AS peer_id
+			GROUP BY peer_id;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_holder_balances_peer_id ON holder_balances(peer_id);
+
+		CREATE MATERIALIZED VIEW IF NOT EXISTS level_distribution AS
+			SELECT l.token_level, l.token_count, l.total_value, COALESCE(h.holder_count, 0) AS holder_count
+			FROM (
+				SELECT ti.token_level, COUNT(*) AS token_count, COALESCE(SUM(ti.token_value), 0) AS total_value
+				FROM current_owners co
+				JOIN token_info ti ON co.token_id = ti.token_id
+				GROUP BY ti.token_level
+			) l
+			LEFT JOIN (
+				SELECT ti.token_level, COUNT(DISTINCT peer_id) AS holder_count
+				FROM current_owners co
+				JOIN token_info ti ON co.token_id = ti.token_id
+				CROSS JOIN LATERAL unnest(co.peer_ids) AS peer_id
+				GROUP BY ti.token_level
+			) h ON l.token_level = h.token_level;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_level_distribution_level ON level_distribution(token_level);
+	`)
+	if retErr != nil {
+		return fmt.Errorf("failed to create materialized views: %w", retErr)
+	}
+
+	// Record the schema version so readiness checks can tell a migrated database
+	_, retErr = tx.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INT PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if retErr == nil {
+		_, retErr = tx.Exec(`INSERT INTO schema_version (version) VALUES ($1) ON CONFLICT (version) DO NOTHING`, schemaVersion)
+	}
+	if retErr != nil {
+		return fmt.Errorf("failed to record schema version: %w", retErr)
+	}
+
 	// Commit the transaction
 	if retErr = tx.Commit(); retErr != nil {
 		return fmt.Errorf("failed to commit transaction: %w", retErr)
 	}
 
-	log.Println("Database schema created successfully")
+	slog.Info("Database schema created")
 	return nil
 }
 
-func upsertTransaction(t Transaction) error {
-	fmt.Println("Upserting transaction:", t)
+func upsertTransaction(ctx context.Context, t Transaction) error {
+	logger := loggerFrom(ctx).With("token_id", t.TokenID)
+	logger.Debug("Upserting transaction", "peers", len(t.PeerID), "epoch", t.Epoch)
 
 	// Verify database connection
 	if err := db.Ping(); err != nil {
@@ -181,31 +358,33 @@ func upsertTransaction(t Transaction) error {
 
 	// If no existing row → insert both into current_owners and transactions
 	if err == sql.ErrNoRows {
-		fmt.Println("No existing row found, inserting new token_id:", t.TokenID)
-		res, err := tx.Exec(`
-				INSERT INTO current_owners (token_id, peer_ids, epoch, quorums, timestamp)
-				VALUES ($1, $2, $3, $4, $5)
+		_, err := tx.Exec(`
+				INSERT INTO current_owners (token_id, peer_ids, epoch, quorums, timestamp, last_checked)
+				VALUES ($1, $2, $3, $4, $5, $5)
 			`, t.TokenID, pq.Array(&t.PeerID), t.Epoch, pq.Array(&t.Quorums), t.Timestamp)
 		if err != nil {
 			return fmt.Errorf("failed to insert into current_owners: %w", err)
 		}
 
-		rowsAffected, _ := res.RowsAffected()
-		fmt.Printf("Inserted into current_owners: %d rows affected\n", rowsAffected)
-
-		_, err = tx.Exec(`
+		var txID int
+		err = tx.QueryRow(`
 				INSERT INTO transactions (token_id, peer_ids, epoch, quorums, timestamp)
 				VALUES ($1, $2, $3, $4, $5)
-			`, t.TokenID, pq.Array(&t.PeerID), t.Epoch, pq.Array(&t.Quorums), t.Timestamp)
+				RETURNING tx_id
+			`, t.TokenID, pq.Array(&t.PeerID), t.Epoch, pq.Array(&t.Quorums), t.Timestamp).Scan(&txID)
 		if err != nil {
 			return fmt.Errorf("failed to insert into transactions: %w", err)
 		}
 
-		fmt.Printf("New token_id %s added to current_owners and transactions", t.TokenID)
+		// First sighting of the token, recorded as a transfer with no previous owners
+		if err := insertTransfer(tx, txID, t, []string{}); err != nil {
+			return err
+		}
+
 	} else {
 		_, err = tx.Exec(`
 					UPDATE current_owners
-					SET peer_ids = $1, epoch = $2, quorums = $3, timestamp = $4
+					SET peer_ids = $1, epoch = $2, quorums = $3, timestamp = $4, last_checked = $4
 					WHERE token_id = $5
 				`, pq.Array(&t.PeerID), t.Epoch, pq.Array(&t.Quorums), t.Timestamp, t.TokenID)
 		if err != nil {
@@ -213,19 +392,41 @@ func upsertTransaction(t Transaction) error {
 		}
 
 		// Insert new transaction
-		_, err = tx.Exec(`
+		var txID int
+		err = tx.QueryRow(`
 					INSERT INTO transactions (token_id, peer_ids, epoch, quorums, timestamp)
 					VALUES ($1, $2, $3, $4, $5)
-				`, t.TokenID, pq.Array(&t.PeerID), t.Epoch, pq.Array(&t.Quorums), t.Timestamp)
+					RETURNING tx_id
+				`, t.TokenID, pq.Array(&t.PeerID), t.Epoch, pq.Array(&t.Quorums), t.Timestamp).Scan(&txID)
 		if err != nil {
 			return fmt.Errorf("failed to insert into transactions (update flow): %w", err)
 		}
+
+		if err := insertTransfer(tx, txID, t, existingPeerIDs); err != nil {
+			return err
+		}
+	}
+
+	if err := enqueueMovedToken(tx, t.TokenID); err != nil {
+		return err
 	}
 
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("commit failed: %w", err)
 	}
 
-	log.Println("Upsert completed")
+	ownershipChangesTotal.Inc()
+	markStatsDirty()
+	invalidateOwnership(existingPeerIDs, t.PeerID)
+	logger.Info("Ownership change recorded", "peer_ids", t.PeerID, "previous_peer_ids", existingPeerIDs)
+	return nil
+}
+
+// markTokenChecked records that a token was checked without an ownership change.
+func markTokenChecked(tokenID string, checkedAt time.Time) error {
+	_, err := db.Exec(`UPDATE current_owners SET last_checked = $1 WHERE token_id = $2`, checkedAt, tokenID)
+	if err != nil {
+		return fmt.Errorf("failed to update last_checked: %w", err)
+	}
 	return nil
 }
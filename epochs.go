@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// EpochSummary describes the activity recorded during one week epoch.
+type EpochSummary struct {
+	Epoch            int       `json:"epoch"`
+	StartDate        time.Time `json:"start_date"`
+	EndDate          time.Time `json:"end_date"`
+	IsCurrent        bool      `json:"is_current"`
+	Transactions     int       `json:"transactions"`
+	OwnershipChanges int       `json:"ownership_changes"` // Transfers between known owners
+	NewTokens        int       `json:"new_tokens"`        // First sightings of a token
+	ActivePeers      int       `json:"active_peers"`
+	QuorumPinners    int       `json:"quorum_pinners"`
+	PublishedCID     string    `json:"published_cid,omitempty"` // Ownership snapshot on IPFS, once published
+}
+
+// fetchEpochSummaries returns summaries for epochs in [from, to], including epochs
+// without any recorded activity.
+func fetchEpochSummaries(from, to int) ([]EpochSummary, error) {
+	current := GetWeeksPassed()
+	summaries := make([]EpochSummary, 0, to-from+1)
+	for n := to; n >= from; n-- {
+		start, end, _ := EpochBounds(n)
+		summaries = append(summaries, EpochSummary{Epoch: n, StartDate: start, EndDate: end, IsCurrent: n == current})
+	}
+
+	byEpoch := make(map[int]*EpochSummary, len(summaries))
+	for i := range summaries {
+		byEpoch[summaries[i].Epoch] = &summaries[i]
+	}
+
+	rows, err := db.Query(`
+		SELECT t.epoch, COUNT(*),
+			(SELECT COUNT(DISTINCT p) FROM transactions x CROSS JOIN LATERAL unnest(x.peer_ids) AS p WHERE x.epoch = t.epoch),
+			(SELECT COUNT(DISTINCT q) FROM transactions x CROSS JOIN LATERAL unnest(x.quorums) AS q WHERE x.epoch = t.epoch)
+		FROM transactions t
+		WHERE t.epoch BETWEEN $1 AND $2
+		GROUP BY t.epoch
+	`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query epoch activity: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var epoch, txCount, peers, quorums int
+		if err := rows.Scan(&epoch, &txCount, &peers, &quorums); err != nil {
+			return nil, fmt.Errorf("failed to scan epoch activity: %w", err)
+		}
+		if s, ok := byEpoch[epoch]; ok {
+			s.Transactions = txCount
+			s.ActivePeers = peers
+			s.QuorumPinners = quorums
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	transferRows, err := db.Query(`
+		SELECT epoch,
+			COUNT(*) FILTER (WHERE cardinality(from_peer_ids) > 0),
+			COUNT(*) FILTER (WHERE cardinality(from_peer_ids) = 0)
+		FROM transfers
+		WHERE epoch BETWEEN $1 AND $2
+		GROUP BY epoch
+	`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query epoch transfers: %w", err)
+	}
+	defer transferRows.Close()
+
+	for transferRows.Next() {
+		var epoch, changes, newTokens int
+		if err := transferRows.Scan(&epoch, &changes, &newTokens); err != nil {
+			return nil, fmt.Errorf("failed to scan epoch transfers: %w", err)
+		}
+		if s, ok := byEpoch[epoch]; ok {
+			s.OwnershipChanges = changes
+			s.NewTokens = newTokens
+		}
+	}
+	if err := transferRows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	publishedRows, err := db.Query(`SELECT epoch, cid FROM published_epochs WHERE epoch BETWEEN $1 AND $2`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query published epochs: %w", err)
+	}
+	defer publishedRows.Close()
+
+	for publishedRows.Next() {
+		var epoch int
+		var cid string
+		if err := publishedRows.Scan(&epoch, &cid); err != nil {
+			return nil, fmt.Errorf("failed to scan published epoch: %w", err)
+		}
+		if s, ok := byEpoch[epoch]; ok {
+			s.PublishedCID = cid
+		}
+	}
+	if err := publishedRows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return summaries, nil
+}
+
+// parseEpoch reads the {epoch} path variable and checks it is a started epoch.
+func parseEpoch(r *http.Request) (int, bool) {
+	n, err := strconv.Atoi(mux.Vars(r)["epoch"])
+	if err != nil || n < 1 || n > GetWeeksPassed() {
+		return 0, false
+	}
+	return n, true
+}
+
+func getEpochs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	page, limit, offset := parsePagination(r, 20)
+
+	// Epochs are listed newest first
+	total := GetWeeksPassed()
+	to := total - offset
+	from := max(to-limit+1, 1)
+
+	summaries := []EpochSummary{}
+	if to >= 1 {
+		var err error
+		summaries, err = fetchEpochSummaries(from, to)
+		if err != nil {
+			http.Error(w, "DB query error", http.StatusInternalServerError)
+			loggerFrom(r.Context()).Error("Epochs query error", "err", err)
+			return
+		}
+	}
+
+	response := map[string]interface{}{
+		"current_epoch": total,
+		"data":          summaries,
+		"pagination":    paginationMeta(total, page, limit),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
+
+func getEpochByNumber(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	n, ok := parseEpoch(r)
+	if !ok {
+		http.Error(w, "Epoch not found", http.StatusNotFound)
+		return
+	}
+
+	summaries, err := fetchEpochSummaries(n, n)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Epoch query error", "err", err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(summaries[0]); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
+
+func getEpochTransactions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	n, ok := parseEpoch(r)
+	if !ok {
+		http.Error(w, "Epoch not found", http.StatusNotFound)
+		return
+	}
+
+	page, limit, offset := parsePagination(r, 30)
+
+	var totalCount int
+	err := db.QueryRow(`SELECT COUNT(*) FROM transactions WHERE epoch = $1`, n).Scan(&totalCount)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get total count: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT tx_id, token_id, peer_ids, epoch, quorums, timestamp
+		FROM transactions
+		WHERE epoch = $1
+		ORDER BY timestamp DESC, tx_id DESC
+		LIMIT $2 OFFSET $3
+	`, n, limit, offset)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("DB query error", "err", err)
+		return
+	}
+	defer rows.Close()
+
+	list := newJSONList(w)
+	for rows.Next() {
+		var t Transaction
+		err := rows.Scan(&t.TxID, &t.TokenID, pq.Array(&t.PeerID), &t.Epoch, pq.Array(&t.Quorums), &t.Timestamp)
+		if err != nil {
+			list.Fail(w, r, "Row scan error", err)
+			return
+		}
+		if err := list.Add(t); err != nil {
+			list.Fail(w, r, "JSON encoding error", err)
+			return
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		list.Fail(w, r, "Rows iteration error", err)
+		return
+	}
+
+	if err := list.Close(map[string]interface{}{"pagination": paginationMeta(totalCount, page, limit)}); err != nil {
+		list.Fail(w, r, "JSON encoding error", err)
+	}
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Rows are buffered this many at a time before being handed to the Parquet writer, and
+// a row group is flushed every exportRowGroupSize rows to bound memory on large dumps.
+const (
+	parquetBatchSize   = 1024
+	exportRowGroupSize = 64 * 1024
+)
+
+// ExportFilter narrows an export. Zero values mean no restriction. After and Limit drive
+// resumable chunking: rows come ordered by the table's key, and a chunk resumes after the
+// key of the last row of the previous one.
+type ExportFilter struct {
+	PeerID    string
+	TokenType string
+	EpochFrom int
+	EpochTo   int
+	From      time.Time
+	To        time.Time
+	After     string
+	Limit     int
+}
+
+type exportRow interface {
+	cursor() string
+	csvRecord() []string
+}
+
+type exportWriter interface {
+	Write(row exportRow) error
+	Close() error
+}
+
+type exportTokenInfo struct {
+	TokenID       string  `json:"token_id" parquet:"token_id"`
+	TokenLevel    int32   `json:"token_level" parquet:"token_level"`
+	TokenNumber   int32   `json:"token_number" parquet:"token_number"`
+	TokenValue    float64 `json:"token_value" parquet:"token_value"`
+	TokenType     string  `json:"token_type" parquet:"token_type"`
+	ParentTokenID *string `json:"parent_token_id" parquet:"parent_token_id,optional"`
+}
+
+type exportTransaction struct {
+	TxID      int64     `json:"tx_id" parquet:"tx_id"`
+	TokenID   string    `json:"token_id" parquet:"token_id"`
+	PeerIDs   []string  `json:"peer_ids" parquet:"peer_ids,list"`
+	Epoch     int32     `json:"epoch" parquet:"epoch"`
+	Quorums   []string  `json:"quorums" parquet:"quorums,list"`
+	Timestamp time.Time `json:"timestamp" parquet:"timestamp"`
+}
+
+type exportCurrentOwner struct {
+	TokenID     string     `json:"token_id" parquet:"token_id"`
+	PeerIDs     []string   `json:"peer_ids" parquet:"peer_ids,list"`
+	Epoch       int32      `json:"epoch" parquet:"epoch"`
+	Quorums     []string   `json:"quorums" parquet:"quorums,list"`
+	Timestamp   time.Time  `json:"timestamp" parquet:"timestamp"`
+	LastChecked *time.Time `json:"last_checked" parquet:"last_checked,optional"`
+}
+
+func (t exportTokenInfo) cursor() string { return t.TokenID }
+
+func (t exportTokenInfo) csvRecord() []string {
+	return []string{
+		t.TokenID,
+		strconv.Itoa(int(t.TokenLevel)),
+		strconv.Itoa(int(t.TokenNumber)),
+		strconv.FormatFloat(t.TokenValue, 'f', -1, 64),
+		t.TokenType,
+		csvOptionalString(t.ParentTokenID),
+	}
+}
+
+func (t exportTransaction) cursor() string { return strconv.FormatInt(t.TxID, 10) }
+
+func (t exportTransaction) csvRecord() []string {
+	return []string{
+		strconv.FormatInt(t.TxID, 10),
+		t.TokenID,
+		csvList(t.PeerIDs),
+		strconv.Itoa(int(t.Epoch)),
+		csvList(t.Quorums),
+		csvTime(t.Timestamp),
+	}
+}
+
+func (o exportCurrentOwner) cursor() string { return o.TokenID }
+
+func (o exportCurrentOwner) csvRecord() []string {
+	lastChecked := ""
+	if o.LastChecked != nil {
+		lastChecked = csvTime(*o.LastChecked)
+	}
+	return []string{
+		o.TokenID,
+		csvList(o.PeerIDs),
+		strconv.Itoa(int(o.Epoch)),
+		csvList(o.Quorums),
+		csvTime(o.Timestamp),
+		lastChecked,
+	}
+}
+
+// Peer IDs and quorum members never contain a semicolon, so lists are joined with one.
+func csvList(values []string) string { return strings.Join(values, ";") }
+
+func csvTime(t time.Time) string { return t.UTC().Format(time.RFC3339Nano) }
+
+func csvOptionalString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// exportTable describes how one table is queried and encoded. The column fields name
+// what each filter applies to; an empty column means the filter is not supported.
+type exportTable struct {
+	name        string
+	from        string
+	columns     string
+	key         string
+	numericKey  bool
+	header      []string
+	peerFilter  string // Condition with a %s placeholder for the peer ID parameter
+	tokenType   string
+	epochColumn string
+	timeColumn  string
+	scan        func(rows *sql.Rows) (exportRow, error)
+	parquet     func(w io.Writer) exportWriter
+}
+
+var exportTables = map[string]exportTable{
+	"token_info": {
+		name:       "token_info",
+		from:       "token_info ti",
+		columns:    "ti.token_id, ti.token_level, ti.token_number, ti.token_value, COALESCE(ti.token_type, ''), ti.parent_token_id",
+		key:        "ti.token_id",
+		header:     []string{"token_id", "token_level", "token_number", "token_value", "token_type", "parent_token_id"},
+		peerFilter: "EXISTS (SELECT 1 FROM current_owners co WHERE co.token_id = ti.token_id AND %s = ANY(co.peer_ids))",
+		tokenType:  "ti.token_type",
+		scan: func(rows *sql.Rows) (exportRow, error) {
+			var t exportTokenInfo
+			var parent sql.NullString
+			if err := rows.Scan(&t.TokenID, &t.TokenLevel, &t.TokenNumber, &t.TokenValue, &t.TokenType, &parent); err != nil {
+				return nil, err
+			}
+			if parent.Valid {
+				t.ParentTokenID = &parent.String
+			}
+			return t, nil
+		},
+		parquet: func(w io.Writer) exportWriter { return newParquetExport[exportTokenInfo](w) },
+	},
+	"transactions": {
+		name:        "transactions",
+		from:        "transactions t JOIN token_info ti ON ti.token_id = t.token_id",
+		columns:     "t.tx_id, t.token_id, t.peer_ids, t.epoch, t.quorums, t.timestamp",
+		key:         "t.tx_id",
+		numericKey:  true,
+		header:      []string{"tx_id", "token_id", "peer_ids", "epoch", "quorums", "timestamp"},
+		peerFilter:  "%s = ANY(t.peer_ids)",
+		tokenType:   "ti.token_type",
+		epochColumn: "t.epoch",
+		timeColumn:  "t.timestamp",
+		scan: func(rows *sql.Rows) (exportRow, error) {
+			var t exportTransaction
+			err := rows.Scan(&t.TxID, &t.TokenID, pq.Array(&t.PeerIDs), &t.Epoch, pq.Array(&t.Quorums), &t.Timestamp)
+			return t, err
+		},
+		parquet: func(w io.Writer) exportWriter { return newParquetExport[exportTransaction](w) },
+	},
+	"current_owners": {
+		name:        "current_owners",
+		from:        "current_owners co JOIN token_info ti ON ti.token_id = co.token_id",
+		columns:     "co.token_id, co.peer_ids, co.epoch, co.quorums, co.timestamp, co.last_checked",
+		key:         "co.token_id",
+		header:      []string{"token_id", "peer_ids", "epoch", "quorums", "timestamp", "last_checked"},
+		peerFilter:  "%s = ANY(co.peer_ids)",
+		tokenType:   "ti.token_type",
+		epochColumn: "co.epoch",
+		timeColumn:  "co.timestamp",
+		scan: func(rows *sql.Rows) (exportRow, error) {
+			var o exportCurrentOwner
+			var lastChecked sql.NullTime
+			err := rows.Scan(&o.TokenID, pq.Array(&o.PeerIDs), &o.Epoch, pq.Array(&o.Quorums), &o.Timestamp, &lastChecked)
+			if lastChecked.Valid {
+				o.LastChecked = &lastChecked.Time
+			}
+			return o, err
+		},
+		parquet: func(w io.Writer) exportWriter { return newParquetExport[exportCurrentOwner](w) },
+	},
+}
+
+// exportFormats maps a format name to its content type and file extension.
+var exportFormats = map[string]struct{ contentType, ext string }{
+	"csv":     {"text/csv; charset=utf-8", "csv"},
+	"ndjson":  {"application/x-ndjson", "ndjson"},
+	"parquet": {"application/vnd.apache.parquet", "parquet"},
+}
+
+// buildQuery renders the SELECT for f. It asks for one row more than the limit, so the
+// caller can tell whether another chunk follows.
+func (t exportTable) buildQuery(f ExportFilter) (string, []interface{}, error) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, "$"+strconv.Itoa(len(args))))
+	}
+
+	if f.PeerID != "" {
+		add(t.peerFilter, f.PeerID)
+	}
+	if f.TokenType != "" {
+		add(t.tokenType+" = %s", f.TokenType)
+	}
+	if f.EpochFrom > 0 || f.EpochTo > 0 {
+		if t.epochColumn == "" {
+			return "", nil, fmt.Errorf("epoch filters do not apply to %s", t.name)
+		}
+		if f.EpochFrom > 0 {
+			add(t.epochColumn+" >= %s", f.EpochFrom)
+		}
+		if f.EpochTo > 0 {
+			add(t.epochColumn+" <= %s", f.EpochTo)
+		}
+	}
+	if !f.From.IsZero() || !f.To.IsZero() {
+		if t.timeColumn == "" {
+			return "", nil, fmt.Errorf("time filters do not apply to %s", t.name)
+		}
+		if !f.From.IsZero() {
+			add(t.timeColumn+" >= %s", f.From)
+		}
+		if !f.To.IsZero() {
+			add(t.timeColumn+" < %s", f.To)
+		}
+	}
+	if f.After != "" {
+		if t.numericKey {
+			after, err := strconv.ParseInt(f.After, 10, 64)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid cursor %q", f.After)
+			}
+			add(t.key+" > %s", after)
+		} else {
+			add(t.key+` COLLATE "C" > %s`, f.After)
+		}
+	}
+
+	query := "SELECT " + t.columns + " FROM " + t.from
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	// Text keys are compared bytewise so the order matches the > cursor condition
+	if t.numericKey {
+		query += " ORDER BY " + t.key
+	} else {
+		query += " ORDER BY " + t.key + ` COLLATE "C"`
+	}
+	if f.Limit > 0 {
+		args = append(args, f.Limit+1)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+	return query, args, nil
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx, so several tables can be exported
+// from one consistent transaction.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// runExport writes the rows matching f to out and returns how many were written and the
+// cursor to resume from. The cursor is empty once the table is exhausted.
+func runExport(ctx context.Context, q queryer, table exportTable, format string, f ExportFilter, out io.Writer) (int, string, error) {
+	query, args, err := table.buildQuery(f)
+	if err != nil {
+		return 0, "", err
+	}
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to query %s: %w", table.name, err)
+	}
+	defer rows.Close()
+
+	var w exportWriter
+	switch format {
+	case "csv":
+		w = newCSVExport(out, table.header)
+	case "ndjson":
+		w = &ndjsonExport{w: out}
+	case "parquet":
+		w = table.parquet(out)
+	default:
+		return 0, "", fmt.Errorf("unknown export format %q", format)
+	}
+
+	count, last, more := 0, "", false
+	for rows.Next() {
+		if f.Limit > 0 && count == f.Limit {
+			more = true
+			break
+		}
+		row, err := table.scan(rows)
+		if err != nil {
+			return count, "", fmt.Errorf("failed to scan %s row: %w", table.name, err)
+		}
+		if err := w.Write(row); err != nil {
+			return count, "", err
+		}
+		count++
+		last = row.cursor()
+	}
+	if err := rows.Err(); err != nil {
+		return count, "", fmt.Errorf("failed to read %s: %w", table.name, err)
+	}
+	if err := w.Close(); err != nil {
+		return count, "", err
+	}
+
+	if !more {
+		last = ""
+	}
+	return count, last, nil
+}
+
+type csvExport struct {
+	w      *csv.Writer
+	header []string
+}
+
+func newCSVExport(w io.Writer, header []string) *csvExport {
+	return &csvExport{w: csv.NewWriter(w), header: header}
+}
+
+func (e *csvExport) Write(row exportRow) error {
+	if e.header != nil {
+		if err := e.w.Write(e.header); err != nil {
+			return err
+		}
+		e.header = nil
+	}
+	return e.w.Write(row.csvRecord())
+}
+
+// Close writes the header for an empty export, so every chunk is a valid CSV file.
+func (e *csvExport) Close() error {
+	if e.header != nil {
+		e.w.Write(e.header)
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonExport struct {
+	w io.Writer
+}
+
+func (e *ndjsonExport) Write(row exportRow) error {
+	b, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(append(b, '\n'))
+	return err
+}
+
+func (e *ndjsonExport) Close() error { return nil }
+
+type parquetExport[T exportRow] struct {
+	w     *parquet.GenericWriter[T]
+	batch []T
+}
+
+func newParquetExport[T exportRow](w io.Writer) *parquetExport[T] {
+	return &parquetExport[T]{
+		w:     parquet.NewGenericWriter[T](w, parquet.MaxRowsPerRowGroup(exportRowGroupSize)),
+		batch: make([]T, 0, parquetBatchSize),
+	}
+}
+
+func (e *parquetExport[T]) Write(row exportRow) error {
+	e.batch = append(e.batch, row.(T))
+	if len(e.batch) < parquetBatchSize {
+		return nil
+	}
+	return e.flush()
+}
+
+func (e *parquetExport[T]) flush() error {
+	if len(e.batch) == 0 {
+		return nil
+	}
+	_, err := e.w.Write(e.batch)
+	e.batch = e.batch[:0]
+	return err
+}
+
+func (e *parquetExport[T]) Close() error {
+	if err := e.flush(); err != nil {
+		return err
+	}
+	return e.w.Close()
+}
+
+// parseExportFilter reads the filter query parameters of /export.
+func parseExportFilter(q url.Values) (ExportFilter, error) {
+	f := ExportFilter{
+		PeerID:    q.Get("peer"),
+		TokenType: q.Get("token_type"),
+		After:     q.Get("after"),
+	}
+
+	ints := []struct {
+		name string
+		dst  *int
+	}{{"epoch_from", &f.EpochFrom}, {"epoch_to", &f.EpochTo}, {"limit", &f.Limit}}
+	for _, p := range ints {
+		if val := q.Get(p.name); val != "" {
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				return f, fmt.Errorf("invalid %s", p.name)
+			}
+			*p.dst = n
+		}
+	}
+
+	times := []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &f.From}, {"to", &f.To}}
+	for _, p := range times {
+		if val := q.Get(p.name); val != "" {
+			t, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				return f, fmt.Errorf("invalid %s, expected RFC 3339", p.name)
+			}
+			*p.dst = t
+		}
+	}
+	return f, nil
+}
+
+// exportTableHandler streams a whole table, or one chunk of it when limit is set. Because
+// the body is streamed, the cursor for the next chunk is sent as the X-Next-Cursor trailer;
+// it is absent once the table is exhausted. The X-Export-Rows trailer carries the row count
+// and is only sent when the export completed, so clients can tell a failed export apart
+// from a body that ends cleanly.
+func exportTableHandler(w http.ResponseWriter, r *http.Request) {
+	table, ok := exportTables[mux.Vars(r)["table"]]
+	if !ok {
+		http.Error(w, "Unknown table", http.StatusNotFound)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	spec, ok := exportFormats[format]
+	if !ok {
+		http.Error(w, "Unsupported format, use csv, ndjson or parquet", http.StatusBadRequest)
+		return
+	}
+
+	f, err := parseExportFilter(r.URL.Query())
+	if err == nil {
+		_, _, err = table.buildQuery(f)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", spec.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, table.name, spec.ext))
+	w.Header().Set("Trailer", "X-Next-Cursor, X-Export-Rows")
+
+	out := &countingWriter{w: w}
+	count, next, err := runExport(r.Context(), db, table, format, f, out)
+	logger := loggerFrom(r.Context()).With("table", table.name, "format", format, "rows", count)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			logger.Info("Export cancelled by client")
+			return
+		}
+		logger.Error("Export failed", "err", err)
+		// Once bytes have been sent the status is fixed and the client gets a truncated body
+		if out.n == 0 {
+			http.Error(w, "Export failed", http.StatusInternalServerError)
+		}
+		return
+	}
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+	w.Header().Set("X-Export-Rows", strconv.Itoa(count))
+	logger.Info("Export finished", "next_cursor", next)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// exportState is kept next to the chunk files so an interrupted export resumes with the
+// chunk after the last one completed. Args guards against resuming with other filters.
+type exportState struct {
+	Args      []string `json:"args"`
+	NextChunk int      `json:"next_chunk"`
+	After     string   `json:"after"`
+	Done      bool     `json:"done"`
+}
+
+// runExportCommand implements `export`: it writes one table to numbered chunk files in
+// the output directory, each one written to a temporary file and renamed when complete.
+func runExportCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	tableName := fs.String("table", "", "table to export: current_owners, transactions or token_info")
+	format := fs.String("format", "csv", "output format: csv, ndjson or parquet")
+	outDir := fs.String("out", ".", "directory the chunk files are written to")
+	chunkSize := fs.Int("chunk-size", 100000, "rows per chunk file, 0 for a single file")
+	peer := fs.String("peer", "", "only rows involving this peer ID")
+	tokenType := fs.String("token-type", "", "only tokens of this type")
+	epochFrom := fs.Int("epoch-from", 0, "first epoch to include")
+	epochTo := fs.Int("epoch-to", 0, "last epoch to include")
+	from := fs.String("from", "", "include rows from this time on (RFC 3339)")
+	to := fs.String("to", "", "include rows before this time (RFC 3339)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	table, ok := exportTables[*tableName]
+	if !ok {
+		return fmt.Errorf("unknown table %q", *tableName)
+	}
+	spec, ok := exportFormats[*format]
+	if !ok {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if *chunkSize < 0 {
+		return errors.New("chunk-size must not be negative")
+	}
+
+	f := ExportFilter{PeerID: *peer, TokenType: *tokenType, EpochFrom: *epochFrom, EpochTo: *epochTo, Limit: *chunkSize}
+	for _, p := range []struct {
+		value string
+		dst   *time.Time
+	}{{*from, &f.From}, {*to, &f.To}} {
+		if p.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, p.value)
+		if err != nil {
+			return fmt.Errorf("invalid time %q: %w", p.value, err)
+		}
+		*p.dst = t
+	}
+	if _, _, err := table.buildQuery(f); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		return err
+	}
+	statePath := filepath.Join(*outDir, fmt.Sprintf("%s.%s.state.json", table.name, spec.ext))
+	state, err := loadExportState(statePath, args)
+	if err != nil {
+		return err
+	}
+	if state.Done {
+		slog.Info("Export already complete", "table", table.name, "state", statePath)
+		return nil
+	}
+
+	for {
+		f.After = state.After
+		name := fmt.Sprintf("%s-%05d.%s", table.name, state.NextChunk, spec.ext)
+		if *chunkSize == 0 {
+			name = table.name + "." + spec.ext
+		}
+
+		count, next, err := writeExportChunk(ctx, table, *format, f, filepath.Join(*outDir, name))
+		if err != nil {
+			return err
+		}
+		slog.Info("Export chunk written", "file", name, "rows", count)
+
+		state.NextChunk++
+		state.After = next
+		state.Done = next == ""
+		if err := saveExportState(statePath, state); err != nil {
+			return err
+		}
+		if state.Done {
+			slog.Info("Export complete", "table", table.name, "chunks", state.NextChunk-1)
+			return nil
+		}
+	}
+}
+
+func writeExportChunk(ctx context.Context, table exportTable, format string, f ExportFilter, path string) (int, string, error) {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return 0, "", err
+	}
+
+	count, next, err := runExport(ctx, db, table, format, f, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, "", err
+	}
+	return count, next, os.Rename(tmp, path)
+}
+
+func loadExportState(path string, args []string) (exportState, error) {
+	state := exportState{Args: args, NextChunk: 1}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	var saved exportState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return state, fmt.Errorf("failed to read export state %s: %w", path, err)
+	}
+	if fmt.Sprint(saved.Args) != fmt.Sprint(args) {
+		return state, fmt.Errorf("%s belongs to an export with other arguments (%v); remove it to start over", path, saved.Args)
+	}
+	return saved, nil
+}
+
+func saveExportState(path string, state exportState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
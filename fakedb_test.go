@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+// fakeResult is what a fakeDB query answers with.
+type fakeResult struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// fakeQuery answers one query of the code under test.
+type fakeQuery func(query string, args []driver.Value) (fakeResult, error)
+
+var (
+	fakeDriverOnce sync.Once
+	fakeQueryFn    fakeQuery
+)
+
+// useFakeDB points the global db at a driver that answers every query with answer, for
+// tests of handlers whose SQL needs no real database to exercise.
+func useFakeDB(t *testing.T, answer fakeQuery) {
+	t.Helper()
+	fakeDriverOnce.Do(func() { sql.Register("fakedb", fakeDriver{}) })
+
+	fake, err := sql.Open("fakedb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db
+	db, fakeQueryFn = fake, answer
+	t.Cleanup(func() {
+		fake.Close()
+		db, fakeQueryFn = previous, nil
+	})
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{query: query}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("fakedb: transactions not supported")
+}
+
+type fakeStmt struct {
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if _, err := fakeQueryFn(s.query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	res, err := fakeQueryFn(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{result: res}, nil
+}
+
+type fakeRows struct {
+	result fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string { return r.result.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"decentralized-explorer-backend/ipfs"
+)
+
+const (
+	healthCheckTimeout = 3 * time.Second
+	// A job is stale once its next run after the last success is overdue by this much
+	jobFreshnessGrace = time.Hour
+)
+
+// Check statuses, from best to worst.
+const (
+	checkOK       = "ok"
+	checkDegraded = "degraded"
+	checkFail     = "fail"
+)
+
+var (
+	startedAt    = time.Now()
+	shuttingDown atomic.Bool // Set on SIGINT/SIGTERM so load balancers stop routing here while draining
+)
+
+// HealthCheck is the result of one readiness check. Failing critical checks make the
+// replica unready; the others only degrade it.
+type HealthCheck struct {
+	Status   string      `json:"status"`
+	Critical bool        `json:"critical"`
+	Error    string      `json:"error,omitempty"`
+	Details  interface{} `json:"details,omitempty"`
+	Duration string      `json:"duration"`
+}
+
+// HealthReport is the /readyz response body.
+type HealthReport struct {
+	Status    string                 `json:"status"`
+	Replica   string                 `json:"replica"`
+	IsLeader  bool                   `json:"isLeader"`
+	Uptime    string                 `json:"uptime"`
+	CheckedAt time.Time              `json:"checked_at"`
+	Checks    map[string]HealthCheck `json:"checks"`
+}
+
+// JobFreshness is the per-job detail of the jobs check.
+type JobFreshness struct {
+	LastSuccess *time.Time `json:"last_success"`
+	Overdue     bool       `json:"overdue"`
+}
+
+type healthCheckFunc func(ctx context.Context) (details interface{}, status string, err error)
+
+type healthCheckDef struct {
+	name     string
+	critical bool
+	run      healthCheckFunc
+}
+
+var healthChecks = []healthCheckDef{
+	{"database", true, checkDatabase},
+	{"schema", true, checkSchemaVersion},
+	{"ipfs_api", true, checkIPFSAPI},
+	{"ipfs_swarm", false, checkIPFSSwarm},
+	{"jobs", false, checkJobFreshness},
+}
+
+func checkDatabase(ctx context.Context) (interface{}, string, error) {
+	if err := db.PingContext(ctx); err != nil {
+		return nil, checkFail, err
+	}
+	stats := db.Stats()
+	return map[string]int{"open": stats.OpenConnections, "in_use": stats.InUse}, checkOK, nil
+}
+
+func checkSchemaVersion(ctx context.Context) (interface{}, string, error) {
+	var version int
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return nil, checkFail, err
+	}
+
+	details := map[string]int{"version": version, "expected": schemaVersion}
+	if version < schemaVersion {
+		return details, checkFail, fmt.Errorf("database schema version %d is older than %d", version, schemaVersion)
+	}
+	return details, checkOK, nil
+}
+
+func checkIPFSAPI(ctx context.Context) (interface{}, string, error) {
+	var out struct{ Version string }
+	if err := ipfs.GetShell().Request("version").Exec(ctx, &out); err != nil {
+		return nil, checkFail, err
+	}
+	return map[string]string{"version": out.Version}, checkOK, nil
+}
+
+// checkIPFSSwarm degrades when the daemon has no peers, since DHT lookups cannot succeed.
+func checkIPFSSwarm(ctx context.Context) (interface{}, string, error) {
+	peers, err := ipfs.GetShell().SwarmPeers(ctx)
+	if err != nil {
+		return nil, checkDegraded, err
+	}
+
+	details := map[string]int{"peers": len(peers.Peers)}
+	if len(peers.Peers) == 0 {
+		return details, checkDegraded, fmt.Errorf("IPFS daemon has no swarm peers")
+	}
+	return details, checkOK, nil
+}
+
+// checkJobFreshness degrades when an enabled job has not succeeded since its last scheduled
+// run was due. Jobs run on the leader but their runs are shared, so every replica agrees.
+func checkJobFreshness(ctx context.Context) (interface{}, string, error) {
+	now := time.Now()
+	details := map[string]JobFreshness{}
+	var stale []string
+
+	for _, job := range scheduler.Jobs() {
+		if !job.Enabled {
+			continue
+		}
+
+		lastSuccess, err := lastSuccessfulRun(ctx, job.Name)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, checkDegraded, err
+		}
+
+		freshness := JobFreshness{}
+		if err == sql.ErrNoRows {
+			freshness.Overdue = true
+		} else {
+			freshness.LastSuccess = &lastSuccess
+			freshness.Overdue = jobOverdue(job, lastSuccess, now)
+		}
+		if freshness.Overdue {
+			stale = append(stale, job.Name)
+		}
+		details[job.Name] = freshness
+	}
+
+	if len(stale) > 0 {
+		return details, checkDegraded, fmt.Errorf("jobs overdue: %v", stale)
+	}
+	return details, checkOK, nil
+}
+
+// jobOverdue reports whether the run due after lastSuccess should have finished by now.
+func jobOverdue(job *Job, lastSuccess, now time.Time) bool {
+	return now.After(job.schedule.Next(lastSuccess).Add(jobFreshnessGrace))
+}
+
+func lastSuccessfulRun(ctx context.Context, name string) (time.Time, error) {
+	var finishedAt time.Time
+	err := db.QueryRowContext(ctx, `
+		SELECT finished_at
+		FROM job_runs
+		WHERE job_name = $1 AND status = 'succeeded'
+		ORDER BY finished_at DESC
+		LIMIT 1
+	`, name).Scan(&finishedAt)
+	return finishedAt, err
+}
+
+// runHealthChecks runs all checks concurrently, each with its own timeout.
+func runHealthChecks(ctx context.Context) HealthReport {
+	report := HealthReport{
+		Status:    checkOK,
+		Replica:   leader.ID(),
+		IsLeader:  leader.IsLeader(),
+		Uptime:    time.Since(startedAt).Round(time.Second).String(),
+		CheckedAt: time.Now().UTC(),
+		Checks:    make(map[string]HealthCheck, len(healthChecks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, def := range healthChecks {
+		wg.Add(1)
+		go func(def healthCheckDef) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			details, status, err := def.run(checkCtx)
+			check := HealthCheck{Status: status, Critical: def.critical, Details: details, Duration: time.Since(start).String()}
+			if err != nil {
+				check.Error = err.Error()
+			}
+
+			mu.Lock()
+			report.Checks[def.name] = check
+			mu.Unlock()
+		}(def)
+	}
+	wg.Wait()
+
+	for _, check := range report.Checks {
+		switch {
+		case check.Status == checkFail && check.Critical:
+			report.Status = checkFail
+		case check.Status != checkOK && report.Status == checkOK:
+			report.Status = checkDegraded
+		}
+	}
+	if shuttingDown.Load() {
+		report.Status = checkFail
+	}
+	return report
+}
+
+// getLiveness only reports that the process is serving requests. It deliberately skips
+// the dependencies, so an outage of Postgres or IPFS does not get the pod restarted.
+func getLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	response := map[string]interface{}{
+		"status": checkOK,
+		"uptime": time.Since(startedAt).Round(time.Second).String(),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
+
+// getReadiness returns 503 when a critical dependency is down or the server is draining,
+// and 200 otherwise, including when only non-critical checks are degraded.
+func getReadiness(w http.ResponseWriter, r *http.Request) {
+	report := runHealthChecks(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status == checkFail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		loggerFrom(r.Context()).Warn("Readiness check failed")
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// parsePointInTime reads the ?at= (RFC3339 or unix seconds) or ?epoch= query parameter
+// and returns the moment ownership should be evaluated at. Without either it returns now.
+// For an epoch the end of that week is used, so the result is the owner when the epoch closed.
+func parsePointInTime(r *http.Request) (time.Time, error) {
+	queryParams := r.URL.Query()
+	at := queryParams.Get("at")
+	epoch := queryParams.Get("epoch")
+
+	switch {
+	case at != "" && epoch != "":
+		return time.Time{}, errors.New("use either at or epoch, not both")
+	case at != "":
+		if t, err := time.Parse(time.RFC3339, at); err == nil {
+			return t, nil
+		}
+		if secs, err := strconv.ParseInt(at, 10, 64); err == nil {
+			return time.Unix(secs, 0).UTC(), nil
+		}
+		return time.Time{}, errors.New("at must be an RFC3339 timestamp or unix seconds")
+	case epoch != "":
+		n, err := strconv.Atoi(epoch)
+		if err != nil {
+			return time.Time{}, errors.New("epoch must be an integer")
+		}
+		_, end, ok := EpochBounds(n)
+		if !ok {
+			return time.Time{}, errors.New("epoch must be 1 or greater")
+		}
+		// Owners recorded up to the last instant of the epoch
+		return end.Add(-time.Nanosecond), nil
+	default:
+		return time.Now().UTC(), nil
+	}
+}
+
+// ownerAt returns the transactions row describing a token's owners at time at.
+// Returns sql.ErrNoRows if the token had not been seen by then.
+func ownerAt(tokenID string, at time.Time) (*Transaction, error) {
+	var t Transaction
+	err := db.QueryRow(`
+		SELECT tx_id, token_id, peer_ids, epoch, quorums, timestamp
+		FROM transactions
+		WHERE token_id = $1 AND timestamp <= $2
+		ORDER BY timestamp DESC, tx_id DESC
+		LIMIT 1
+	`, tokenID, at).Scan(&t.TxID, &t.TokenID, pq.Array(&t.PeerID), &t.Epoch, pq.Array(&t.Quorums), &t.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func getTokenOwnerAt(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	tokenID := vars["tokenID"]
+
+	at, err := parsePointInTime(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := fetchTokenInfo(tokenID); err == sql.ErrNoRows {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Token info query error", "err", err)
+		return
+	}
+
+	owner, err := ownerAt(tokenID, at)
+	if err == sql.ErrNoRows {
+		http.Error(w, "No owner recorded for token at "+at.Format(time.RFC3339), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Owner at query error", "err", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"at":       at,
+		"at_epoch": EpochAt(at),
+		"data":     owner,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
+
+// peerHoldingsAt selects the latest row per token up to $2, limited to tokens the peer ($1)
+// held at that time.
+const peerHoldingsAt = `(
+		SELECT DISTINCT ON (token_id) tx_id, token_id, peer_ids, epoch, quorums, timestamp
+		FROM transactions
+		WHERE timestamp <= $2
+			AND token_id IN (
+				SELECT token_id FROM transactions
+				WHERE peer_ids @> ARRAY[$1]::text[] AND timestamp <= $2
+			)
+		ORDER BY token_id, timestamp DESC, tx_id DESC
+	) latest
+	WHERE peer_ids @> ARRAY[$1]::text[]`
+
+// getPeerHoldingsAt lists the tokens a peer held at a past time or epoch.
+func getPeerHoldingsAt(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	peerID := vars["peerID"]
+
+	at, err := parsePointInTime(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, limit, offset := parsePagination(r, 30)
+
+	// Counted separately so pages past the end still report the real total
+	var total int
+	err = db.QueryRow(`SELECT COUNT(*) FROM `+peerHoldingsAt, peerID, at).Scan(&total)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Holdings at count error", "err", err)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT tx_id, token_id, peer_ids, epoch, quorums, timestamp
+		FROM `+peerHoldingsAt+`
+		ORDER BY timestamp DESC, token_id
+		LIMIT $3 OFFSET $4
+	`, peerID, at, limit, offset)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Holdings at query error", "err", err)
+		return
+	}
+	defer rows.Close()
+
+	holdings := []Transaction{}
+	for rows.Next() {
+		var t Transaction
+		err := rows.Scan(&t.TxID, &t.TokenID, pq.Array(&t.PeerID), &t.Epoch, pq.Array(&t.Quorums), &t.Timestamp)
+		if err != nil {
+			http.Error(w, "DB row scan error", http.StatusInternalServerError)
+			loggerFrom(r.Context()).Error("Row scan error", "err", err)
+			return
+		}
+		holdings = append(holdings, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Rows iteration error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Rows iteration error", "err", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"at":         at,
+		"at_epoch":   EpochAt(at),
+		"data":       holdings,
+		"pagination": paginationMeta(total, page, limit),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
@@ -2,6 +2,7 @@ package ipfs
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -16,8 +17,14 @@ var (
 	sh      *shell.Shell
 	once    sync.Once
 	ipfsAPI string = "localhost:5001" // or from config
+	logger         = slog.Default()
 )
 
+// SetLogger sets the logger used by the package.
+func SetLogger(l *slog.Logger) {
+	logger = l
+}
+
 func GetShell() *shell.Shell {
 	once.Do(func() {
 		sh = shell.NewShell(ipfsAPI)
@@ -25,6 +32,21 @@ func GetShell() *shell.Shell {
 	return sh
 }
 
+// DaemonRunning reports whether the daemon answering on the shell's API address is the one
+// serving the repo in appDir. A running daemon writes its API multiaddr to the repo's api
+// file, so a daemon of some other repo on the same port is not mistaken for ours.
+func DaemonRunning(appDir string) bool {
+	data, err := os.ReadFile(filepath.Join(appDir, "api"))
+	if err != nil {
+		return false
+	}
+	_, port, _ := strings.Cut(ipfsAPI, ":")
+	if !strings.HasSuffix(strings.TrimSpace(string(data)), "/tcp/"+port) {
+		return false
+	}
+	return GetShell().IsUp()
+}
+
 // func SetAPI(api string) {
 // 	ipfsAPI = api
 // }
@@ -40,6 +62,7 @@ func NewIPFSSetup(appDir string) error {
 	}
 
 	//1. Initialize repo with identical CLI settings
+	logger.Info("Initializing IPFS repo", "path", appDir)
 	cmd := exec.Command(ipfsPath, "init")
 	cmd.Env = append(os.Environ(), "IPFS_PATH="+appDir)
 	cmd.Stdout = os.Stdout
@@ -78,6 +101,7 @@ func NewIPFSSetup(appDir string) error {
 		}
 
 		cmd.Env = append(os.Environ(), "IPFS_PATH="+appDir)
+		logger.Debug("Applying IPFS config", "key", cfg.key)
 		if err := cmd.Run(); err != nil {
 			return fmt.Errorf("config %s failed: %w", cfg.key, err)
 		}
@@ -104,6 +128,7 @@ func StartDaemon(repoPath string) (*exec.Cmd, error) {
 	}
 
 	// First set the Libp2pStreamMounting config
+	logger.Info("Enabling Libp2pStreamMounting, the daemon will restart")
 	setConfigCmd := exec.Command(ipfsPath, "config", "--json", "Experimental.Libp2pStreamMounting", "true")
 	setConfigCmd.Env = append(os.Environ(), "IPFS_PATH="+repoPath)
 	if err := setConfigCmd.Run(); err != nil {
@@ -136,6 +161,7 @@ func startipfsdaemon(repoPath string) (*exec.Cmd, error) {
 	if err := cmd.Start(); err != nil {
 		return nil, fmt.Errorf("failed to start daemon: %w", err)
 	}
+	logger.Info("IPFS daemon started", "pid", cmd.Process.Pid)
 
 	return cmd, nil
 }
@@ -152,3 +178,26 @@ func stopipfsdaemon(cmd *exec.Cmd) error {
 	}
 	return nil
 }
+
+// StopDaemon asks the daemon to shut down and waits up to timeout for it to exit,
+// killing it if it does not.
+func StopDaemon(cmd *exec.Cmd, timeout time.Duration) error {
+	if cmd == nil || cmd.Process == nil {
+		return fmt.Errorf("daemon process not running")
+	}
+
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		return stopipfsdaemon(cmd)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		logger.Warn("IPFS daemon did not exit in time, killing it", "timeout", timeout)
+		return stopipfsdaemon(cmd)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// leaderLockKey is the Postgres advisory lock all explorer replicas compete for.
+const leaderLockKey int64 = 0x52425845 // "RBXE"
+
+// replicaLockClass namespaces the per-replica liveness locks. They use the two-key form of
+// the advisory lock functions, which never collides with the single-key leader lock.
+const replicaLockClass int32 = 0x52425852 // "RBXR"
+
+// leaderPollInterval is how often followers try to take over and the leader checks its session.
+const leaderPollInterval = 10 * time.Second
+
+// LeaderElector makes sure only one replica sharing the database runs background jobs.
+// Leadership is a session-level advisory lock held on a dedicated connection, so it is
+// released by Postgres as soon as the leader process dies or loses its connection.
+type LeaderElector struct {
+	db       *sql.DB
+	id       string
+	isLeader atomic.Bool
+}
+
+var leader *LeaderElector
+
+func NewLeaderElector(db *sql.DB) *LeaderElector {
+	hostname, _ := os.Hostname()
+	return &LeaderElector{db: db, id: fmt.Sprintf("%s-%d", hostname, os.Getpid())}
+}
+
+// IsLeader reports whether this replica currently runs the background jobs.
+func (le *LeaderElector) IsLeader() bool {
+	return le != nil && le.isLeader.Load()
+}
+
+// ID identifies this replica in logs and status responses.
+func (le *LeaderElector) ID() string {
+	if le == nil {
+		return ""
+	}
+	return le.id
+}
+
+// Run campaigns for leadership until ctx is cancelled. onElected is called with a context
+// that is cancelled when leadership is lost; drain is then called before the lock is
+// released, so no follower starts jobs while this replica's are still running.
+func (le *LeaderElector) Run(ctx context.Context, onElected func(ctx context.Context), drain func()) {
+	ticker := time.NewTicker(leaderPollInterval)
+	defer ticker.Stop()
+
+	for {
+		conn, err := le.tryAcquire(ctx)
+		if err != nil {
+			slog.Error("Leader election error", "err", err)
+		}
+
+		if conn != nil {
+			slog.Info("Elected leader, starting background jobs", "replica", le.id)
+			le.isLeader.Store(true)
+
+			// Held while this replica may run jobs, so the next leader can tell whether
+			// runs recorded by this replica are still in progress
+			alive, err := le.holdReplicaLock(ctx)
+			if err != nil {
+				slog.Error("Failed to take replica lock", "err", err)
+			}
+
+			leaderCtx, cancel := context.WithCancel(ctx)
+			onElected(leaderCtx)
+			le.holdLeadership(leaderCtx, conn, ticker)
+			cancel()
+			drain()
+
+			le.isLeader.Store(false)
+			if alive != nil {
+				le.releaseReplicaLock(alive)
+				alive.Close()
+			}
+			le.release(conn)
+			conn.Close()
+			slog.Info("Lost leadership, background jobs stopped", "replica", le.id)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tryAcquire returns the connection holding the lock, or nil if another replica is leader.
+func (le *LeaderElector) tryAcquire(ctx context.Context) (*sql.Conn, error) {
+	conn, err := le.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, leaderLockKey).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to try advisory lock: %w", err)
+	}
+
+	if !acquired {
+		conn.Close()
+		return nil, nil
+	}
+	return conn, nil
+}
+
+// holdLeadership returns once the lock session is lost or ctx is cancelled.
+func (le *LeaderElector) holdLeadership(ctx context.Context, conn *sql.Conn, ticker *time.Ticker) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := conn.PingContext(ctx); err != nil {
+			slog.Warn("Leader connection lost", "err", err)
+			return
+		}
+	}
+}
+
+// holdReplicaLock takes this replica's liveness lock on a dedicated connection.
+func (le *LeaderElector) holdReplicaLock(ctx context.Context) (*sql.Conn, error) {
+	conn, err := le.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1, hashtext($2))`, replicaLockClass, le.id); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to take replica lock: %w", err)
+	}
+	return conn, nil
+}
+
+func (le *LeaderElector) releaseReplicaLock(conn *sql.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1, hashtext($2))`, replicaLockClass, le.id); err != nil {
+		slog.Error("Failed to release replica lock", "err", err)
+	}
+}
+
+// ReplicaAlive reports whether the replica still holds its liveness lock, that is whether
+// its process and database session are still up.
+func (le *LeaderElector) ReplicaAlive(ctx context.Context, replica string) (bool, error) {
+	conn, err := le.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	var acquired bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1, hashtext($2))`, replicaLockClass, replica).Scan(&acquired)
+	if err != nil {
+		return false, fmt.Errorf("failed to try replica lock: %w", err)
+	}
+	if acquired {
+		_, err = conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1, hashtext($2))`, replicaLockClass, replica)
+	}
+	return !acquired, err
+}
+
+// release unlocks explicitly so a follower can take over without waiting for the session to end.
+func (le *LeaderElector) release(conn *sql.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, leaderLockKey); err != nil {
+		slog.Error("Failed to release leader lock", "err", err)
+	}
+}
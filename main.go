@@ -1,60 +1,87 @@
 package main
 
 import (
-	"decentralized-explorer-backend/ipfs"
+	"context"
+	"flag"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run parses the global flags, sets up what the chosen command needs and runs it. It
+// returns the exit code so deferred cleanup (IPFS daemon, database) runs before exiting.
+func run() int {
+	configFlag := flag.String("config", "", "config file (default: $EXPLORER_CONFIG or config.json next to the executable)")
+	flag.Usage = printUsage
+	flag.Parse()
+
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	command := findCommand(name)
+	if command == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		printUsage()
+		return 2
+	}
 
 	appDir, err := getAppDir()
 	if err != nil {
 		fmt.Printf("Error getting application directory: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 
-	// Setup IPFS environment
-	err = ipfs.NewIPFSSetup(appDir)
-	// if err := ipfsSetup.EnsureIPFS();
+	cfg, err = loadConfig(configPath(*configFlag, appDir))
 	if err != nil {
-		fmt.Printf("IPFS setup failed: %v\n", err)
-		os.Exit(1)
+		fmt.Printf("Error loading config: %v\n", err)
+		return 1
 	}
 
-	daemonCmd, err := ipfs.StartDaemon(appDir)
-	if err != nil {
-		log.Fatalf("Failed to start daemon: %v", err)
+	if err := setupLogger(cfg.Log); err != nil {
+		fmt.Printf("Error configuring logger: %v\n", err)
+		return 1
 	}
-	defer daemonCmd.Process.Kill()
 
-	if err := setupDatabase(); err != nil {
-		log.Fatal("Database setup failed:", err)
+	// Commands parse their flags before touching IPFS or the database, so -h works without either
+	if wantsHelp(args) {
+		command.run(context.Background(), args)
+		return 2
 	}
-	defer db.Close()
 
-	router := setupRoutes()
+	// Cancelled on SIGINT/SIGTERM; commands and background jobs derive their context from it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// checkTokenCount()
-	// if err := syncMissingCurrentOwners(); err != nil {
-	// 	log.Println("Error during initial sync:", err)
-	// }
-
-	// Periodic weekly sync to check newly minted tokens(runs in the background)
-	go startWeeklySync()
-	go startDailyPinCheck()
-	// err = checkPins("QmQPG1tw3TqEbQGvs8AS89LWNsWmn9zzoPcyZbSPucdXne")
-	// if err != nil {
-	// 	log.Println("Error checking pins:", err)
-	// }
-
-	log.Println("Server started on :3000")
-
-	handlerWithCORS := enableCORS(router)
-	log.Fatal(http.ListenAndServe(":3000", handlerWithCORS))
+	if command.ipfs {
+		stopIPFS, err := ensureIPFS(appDir)
+		if err != nil {
+			slog.Error("Failed to start IPFS", "err", err)
+			return 1
+		}
+		defer stopIPFS()
+	}
 
-	// log.Fatal(http.ListenAndServe(":3000", router))
+	if command.db {
+		if err := setupDatabase(); err != nil {
+			slog.Error("Database setup failed", "err", err)
+			return 1
+		}
+		defer db.Close()
+	}
 
+	if err := command.run(ctx, args); err != nil {
+		if err == flag.ErrHelp {
+			return 2
+		}
+		slog.Error("Command failed", "command", name, "err", err)
+		return 1
+	}
+	return 0
 }
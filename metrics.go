@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "explorer"
+
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	dhtLookupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "dht_lookup_duration_seconds",
+		Help:      "Duration of GetDHTddrs provider lookups.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 20, 30, 60, 120},
+	}, []string{"result"})
+
+	dhtProviders = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "dht_providers",
+		Help:      "Number of providers returned by successful GetDHTddrs lookups.",
+		Buckets:   []float64{0, 1, 2, 3, 5, 8, 13, 21},
+	})
+
+	ipfsAddDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "ipfs_add_duration_seconds",
+		Help:      "Duration of only-hash ipfs Add calls.",
+		Buckets:   []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+	}, []string{"kind"})
+
+	pinChecksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "pin_checks_total",
+		Help:      "checkPins results: changed, unchanged, unknown_token or error.",
+	}, []string{"result"})
+
+	ownershipChangesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "ownership_changes_total",
+		Help:      "Ownership changes written by upsertTransaction.",
+	})
+
+	tokensGeneratedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tokens_generated_total",
+		Help:      "Token IDs inserted into token_info by generateTokenID.",
+	})
+
+	tokenGenerationPosition = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "token_generation_position",
+		Help:      "Level and number of the last token ID generated.",
+	}, []string{"field"})
+
+	cacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_requests_total",
+		Help:      "Response cache lookups by result: hit or miss.",
+	}, []string{"result"})
+
+	reconcileDisagreements = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_disagreements",
+		Help:      "Tokens the last reconcile run disagreed with a peer explorer on, by kind.",
+	}, []string{"peer", "kind"})
+
+	jobRunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "job_runs_total",
+		Help:      "Background job runs by job and final status.",
+	}, []string{"job", "status"})
+
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "job_duration_seconds",
+		Help:      "Background job run duration.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"job"})
+
+	jobProcessedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "job_processed_total",
+		Help:      "Items processed by background jobs.",
+	}, []string{"job"})
+
+	jobErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "job_errors_total",
+		Help:      "Item errors reported by background jobs.",
+	}, []string{"job"})
+)
+
+// registerDBMetrics exposes the connection pool stats of db and the check queue gauges.
+// Called once the database is open.
+func registerDBMetrics() {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "decentralized_explorer"))
+	prometheus.MustRegister(queueCollector{})
+}
+
+var (
+	queueTokensDesc = prometheus.NewDesc(metricsNamespace+"_check_queue_tokens", "Tokens in the pin check queue.", nil, nil)
+	queueDueDesc    = prometheus.NewDesc(metricsNamespace+"_check_queue_due", "Tokens in the pin check queue whose next check is due.", nil, nil)
+	queueLagDesc    = prometheus.NewDesc(metricsNamespace+"_check_queue_lag_seconds", "Age of the oldest due next_check, 0 when nothing is due.", nil, nil)
+)
+
+// queueCollector reads the check queue depth and lag on every scrape, so the gauges are
+// current even while the pin-check-queue job is not running.
+type queueCollector struct{}
+
+func (queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueTokensDesc
+	ch <- queueDueDesc
+	ch <- queueLagDesc
+}
+
+func (queueCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var total, due int
+	var lag float64
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE next_check <= NOW()),
+			COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(next_check) FILTER (WHERE next_check <= NOW())), 0)
+		FROM check_queue
+	`).Scan(&total, &due, &lag)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(queueDueDesc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(queueTokensDesc, prometheus.GaugeValue, float64(total))
+	ch <- prometheus.MustNewConstMetric(queueDueDesc, prometheus.GaugeValue, float64(due))
+	ch <- prometheus.MustNewConstMetric(queueLagDesc, prometheus.GaugeValue, lag)
+}
+
+func observeDHTLookup(start time.Time, providers int, err error) {
+	if err != nil {
+		dhtLookupDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
+		return
+	}
+	dhtLookupDuration.WithLabelValues("ok").Observe(time.Since(start).Seconds())
+	dhtProviders.Observe(float64(providers))
+}
+
+func observeJobRun(name string, start time.Time, status string, result JobResult) {
+	jobRunsTotal.WithLabelValues(name, status).Inc()
+	jobDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	jobProcessedTotal.WithLabelValues(name).Add(float64(result.Processed))
+	jobErrorsTotal.WithLabelValues(name).Add(float64(result.Errors))
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+type routeKey struct{}
+
+// metricsMiddleware records request latency labelled by the matched route template,
+// so per-token and per-peer URLs do not explode the label cardinality. It wraps the whole
+// handler chain so 404s, 405s and preflights are counted too; mux only runs router
+// middleware on matched routes, so recordRoute reports the template back through the context.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		route := "unmatched"
+
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), routeKey{}, &route)))
+
+		httpRequestDuration.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// recordRoute stores the matched route template for metricsMiddleware.
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+			if current := mux.CurrentRoute(r); current != nil {
+				if tmpl, err := current.GetPathTemplate(); err == nil {
+					*route = tmpl
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// HoldingSummary groups a peer's currently held tokens by type and level.
+type HoldingSummary struct {
+	TokenType  string  `json:"token_type"`
+	TokenLevel int     `json:"token_level"`
+	Count      int     `json:"count"`
+	TotalValue float64 `json:"total_value"`
+}
+
+// PeerActivity counts tokens a peer received and sent during one epoch.
+type PeerActivity struct {
+	Epoch    int `json:"epoch"`
+	Received int `json:"received"`
+	Sent     int `json:"sent"`
+}
+
+// PeerProfile is the response of /peers/{peerID}.
+type PeerProfile struct {
+	PeerID           string           `json:"peer_id"`
+	TokensHeld       int              `json:"tokens_held"`
+	TotalValue       float64          `json:"total_value"`
+	Holdings         []HoldingSummary `json:"holdings"`
+	Activity         []PeerActivity   `json:"activity"`
+	FirstSeen        *time.Time       `json:"first_seen"`
+	LastSeen         *time.Time       `json:"last_seen"`
+	QuorumAppearance int              `json:"quorum_appearances"`
+	QuorumEpochs     int              `json:"quorum_epochs"`
+	Transfers        []Transfer       `json:"transfers"`
+}
+
+func fetchPeerHoldings(peerID string) ([]HoldingSummary, error) {
+	key := holdingsCacheKey(peerID, "summary")
+	if cached, ok := responseCache.Get(key); ok {
+		return cached.([]HoldingSummary), nil
+	}
+
+	rows, err := db.Query(`
+		SELECT COALESCE(ti.token_type, ''), ti.token_level, COUNT(*), COALESCE(SUM(ti.token_value), 0)
+		FROM current_owners co
+		JOIN token_info ti ON co.token_id = ti.token_id
+		WHERE $1 = ANY(co.peer_ids)
+		GROUP BY ti.token_type, ti.token_level
+		ORDER BY ti.token_type, ti.token_level
+	`, peerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query holdings: %w", err)
+	}
+	defer rows.Close()
+
+	holdings := []HoldingSummary{}
+	for rows.Next() {
+		var h HoldingSummary
+		if err := rows.Scan(&h.TokenType, &h.TokenLevel, &h.Count, &h.TotalValue); err != nil {
+			return nil, fmt.Errorf("failed to scan holding: %w", err)
+		}
+		holdings = append(holdings, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	responseCache.Set(key, holdings)
+	return holdings, nil
+}
+
+func fetchPeerActivity(peerID string) ([]PeerActivity, error) {
+	rows, err := db.Query(`
+		SELECT epoch,
+			COUNT(*) FILTER (WHERE to_peer_ids @> ARRAY[$1]::text[]),
+			COUNT(*) FILTER (WHERE from_peer_ids @> ARRAY[$1]::text[])
+		FROM transfers
+		WHERE `+peerTransfersFilter+`
+		GROUP BY epoch
+		ORDER BY epoch
+	`, peerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query peer activity: %w", err)
+	}
+	defer rows.Close()
+
+	activity := []PeerActivity{}
+	for rows.Next() {
+		var a PeerActivity
+		if err := rows.Scan(&a.Epoch, &a.Received, &a.Sent); err != nil {
+			return nil, fmt.Errorf("failed to scan peer activity: %w", err)
+		}
+		activity = append(activity, a)
+	}
+	return activity, rows.Err()
+}
+
+func getPeerProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	peerID := vars["peerID"]
+
+	page, limit, offset := parsePagination(r, 30)
+
+	profile := PeerProfile{PeerID: peerID}
+
+	var firstSeen, lastSeen sql.NullTime
+	err := db.QueryRow(`
+		SELECT MIN(timestamp), MAX(timestamp),
+			COUNT(*) FILTER (WHERE $1 = ANY(quorums)),
+			COUNT(DISTINCT epoch) FILTER (WHERE $1 = ANY(quorums))
+		FROM transactions
+		WHERE $1 = ANY(peer_ids) OR $1 = ANY(quorums)
+	`, peerID).Scan(&firstSeen, &lastSeen, &profile.QuorumAppearance, &profile.QuorumEpochs)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Peer seen query error", "err", err)
+		return
+	}
+
+	if !firstSeen.Valid {
+		http.Error(w, "Peer not found", http.StatusNotFound)
+		return
+	}
+	profile.FirstSeen = &firstSeen.Time
+	profile.LastSeen = &lastSeen.Time
+
+	profile.Holdings, err = fetchPeerHoldings(peerID)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Peer holdings query error", "err", err)
+		return
+	}
+	for _, h := range profile.Holdings {
+		profile.TokensHeld += h.Count
+		profile.TotalValue += h.TotalValue
+	}
+
+	profile.Activity, err = fetchPeerActivity(peerID)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Peer activity query error", "err", err)
+		return
+	}
+
+	totalTransfers, err := countPeerTransfers(peerID)
+	if err == nil {
+		profile.Transfers = []Transfer{}
+		err = fetchPeerTransfers(peerID, limit, offset, func(t Transfer) error {
+			profile.Transfers = append(profile.Transfers, t)
+			return nil
+		})
+	}
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Peer transfers query error", "err", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"data":       profile,
+		"pagination": paginationMeta(totalTransfers, page, limit),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"decentralized-explorer-backend/ipfs"
+
+	"github.com/gorilla/mux"
+	shell "github.com/ipfs/go-ipfs-api"
+	"github.com/ipfs/go-ipfs-api/options"
+	"github.com/lib/pq"
+)
+
+// epochSnapshotType identifies the layout of published epoch snapshots, so other
+// explorers know how to read them.
+const epochSnapshotType = "rubix-explorer/epoch-ownership"
+
+// cidLink is the DAG-JSON encoding of a link to another IPLD node.
+type cidLink struct {
+	CID string `json:"/"`
+}
+
+// OwnershipEntry is the owner of one token when an epoch closed, taken from the last
+// transactions row recorded before the epoch end.
+type OwnershipEntry struct {
+	TokenID   string    `json:"token_id"`
+	PeerIDs   []string  `json:"peer_ids"`
+	TxID      int64     `json:"tx_id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type ownershipShard struct {
+	Epoch  int              `json:"epoch"`
+	Tokens []OwnershipEntry `json:"tokens"`
+}
+
+// shardRef links a shard from the epoch root. Shards hold tokens in token_id order, so
+// First and Last let a reader fetch only the shard a token falls in.
+type shardRef struct {
+	First string  `json:"first"`
+	Last  string  `json:"last"`
+	Count int     `json:"count"`
+	Data  cidLink `json:"data"`
+}
+
+// epochSnapshot is the root node of a published epoch. Previous links the snapshot of the
+// epoch published before it, so the whole history can be walked from the IPNS pointer.
+type epochSnapshot struct {
+	Type     string     `json:"type"`
+	Version  int        `json:"version"`
+	Epoch    int        `json:"epoch"`
+	Start    time.Time  `json:"start"`
+	End      time.Time  `json:"end"`
+	Tokens   int64      `json:"tokens"`
+	Shards   []shardRef `json:"shards"`
+	Previous *cidLink   `json:"previous"`
+}
+
+// PublishedEpoch is a row of published_epochs.
+type PublishedEpoch struct {
+	Epoch       int       `json:"epoch"`
+	CID         string    `json:"cid"`
+	Tokens      int64     `json:"tokens"`
+	Shards      int       `json:"shards"`
+	IPNSName    *string   `json:"ipns_name"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// runStatePublish publishes the ownership snapshot of every completed epoch not published
+// yet, oldest first, then points the IPNS name at the newest one. On the first run only
+// the last completed epoch is published.
+func runStatePublish(ctx context.Context) (JobResult, error) {
+	logger := loggerFrom(ctx)
+	lastComplete := GetWeeksPassed() - 1
+	if lastComplete < 1 {
+		return JobResult{}, nil
+	}
+
+	var prev sql.NullString
+	var latest sql.NullInt64
+	var pointed bool
+	err := db.QueryRowContext(ctx, `
+		SELECT epoch, cid, ipns_name IS NOT NULL
+		FROM published_epochs
+		ORDER BY epoch DESC LIMIT 1
+	`).Scan(&latest, &prev, &pointed)
+	if err != nil && err != sql.ErrNoRows {
+		return JobResult{Errors: 1}, fmt.Errorf("failed to read published epochs: %w", err)
+	}
+	from := lastComplete
+	if latest.Valid {
+		from = int(latest.Int64) + 1
+	}
+	to := lastComplete
+	if limit := cfg.Publish.MaxEpochsPerRun; limit > 0 && to-from+1 > limit {
+		to = from + limit - 1
+	}
+
+	var result JobResult
+	for n := from; n <= to; n++ {
+		var previous *cidLink
+		if prev.Valid {
+			previous = &cidLink{CID: prev.String}
+		}
+		published, err := publishEpoch(ctx, n, previous)
+		if err != nil {
+			result.Errors++
+			return result, fmt.Errorf("failed to publish epoch %d: %w", n, err)
+		}
+		result.Processed++
+		prev = sql.NullString{String: published.CID, Valid: true}
+		logger.Info("Epoch snapshot published", "epoch", n, "cid", published.CID, "tokens", published.Tokens, "shards", published.Shards)
+	}
+
+	// Also retries the IPNS update when it failed on an earlier run
+	if cfg.Publish.IPNSKey == "" || !prev.Valid || (result.Processed == 0 && pointed) {
+		return result, nil
+	}
+	newest := to
+	if result.Processed == 0 {
+		newest = int(latest.Int64)
+	}
+	name, err := publishIPNS(ctx, prev.String, newest)
+	if err != nil {
+		result.Errors++
+		return result, err
+	}
+	logger.Info("IPNS pointer updated", "name", name, "cid", prev.String)
+	return result, nil
+}
+
+// publishEpoch stores the owners of every token at the end of epoch n as pinned DAG-JSON
+// shards plus a root node, and records the root CID.
+func publishEpoch(ctx context.Context, n int, previous *cidLink) (*PublishedEpoch, error) {
+	start, end, _ := EpochBounds(n)
+	root := epochSnapshot{Type: epochSnapshotType, Version: 1, Epoch: n, Start: start, End: end, Shards: []shardRef{}, Previous: previous}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT DISTINCT ON (token_id COLLATE "C") token_id, peer_ids, tx_id, timestamp
+		FROM transactions
+		WHERE timestamp < $1
+		ORDER BY token_id COLLATE "C", timestamp DESC, tx_id DESC
+	`, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query owners: %w", err)
+	}
+	defer rows.Close()
+
+	shardSize := cfg.Publish.ShardSize
+	shard := ownershipShard{Epoch: n, Tokens: make([]OwnershipEntry, 0, shardSize)}
+	flush := func() error {
+		if len(shard.Tokens) == 0 {
+			return nil
+		}
+		cid, err := putDAGJSON(shard)
+		if err != nil {
+			return err
+		}
+		root.Shards = append(root.Shards, shardRef{
+			First: shard.Tokens[0].TokenID,
+			Last:  shard.Tokens[len(shard.Tokens)-1].TokenID,
+			Count: len(shard.Tokens),
+			Data:  cidLink{CID: cid},
+		})
+		root.Tokens += int64(len(shard.Tokens))
+		shard.Tokens = shard.Tokens[:0]
+		return ctx.Err()
+	}
+
+	for rows.Next() {
+		var e OwnershipEntry
+		if err := rows.Scan(&e.TokenID, pq.Array(&e.PeerIDs), &e.TxID, &e.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan owner: %w", err)
+		}
+		shard.Tokens = append(shard.Tokens, e)
+		if len(shard.Tokens) >= shardSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read owners: %w", err)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	cid, err := putDAGJSON(root)
+	if err != nil {
+		return nil, err
+	}
+
+	published := &PublishedEpoch{Epoch: n, CID: cid, Tokens: root.Tokens, Shards: len(root.Shards)}
+	err = db.QueryRowContext(ctx, `
+		INSERT INTO published_epochs (epoch, cid, tokens, shards)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (epoch) DO UPDATE
+		SET cid = EXCLUDED.cid, tokens = EXCLUDED.tokens, shards = EXCLUDED.shards,
+			ipns_name = NULL, published_at = NOW()
+		RETURNING published_at
+	`, n, cid, published.Tokens, published.Shards).Scan(&published.PublishedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record published epoch: %w", err)
+	}
+	return published, nil
+}
+
+// putDAGJSON stores v as a pinned DAG-JSON node and returns its CID.
+func putDAGJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	cid, err := ipfs.GetShell().DagPutWithOpts(data,
+		options.Dag.InputCodec("dag-json"),
+		options.Dag.StoreCodec("dag-json"),
+		options.Dag.Pin("true"),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to put DAG node: %w", err)
+	}
+	return cid, nil
+}
+
+// publishIPNS points the configured IPNS key at cid, creating the key on first use, and
+// records the name on the epoch's row.
+func publishIPNS(ctx context.Context, cid string, epoch int) (string, error) {
+	sh := ipfs.GetShell()
+	key := cfg.Publish.IPNSKey
+
+	keys, err := sh.KeyList(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list IPFS keys: %w", err)
+	}
+	found := false
+	for _, k := range keys {
+		if k.Name == key {
+			found = true
+			break
+		}
+	}
+	if !found {
+		if _, err := sh.KeyGen(ctx, key, shell.KeyGen.Type("ed25519")); err != nil {
+			return "", fmt.Errorf("failed to create IPNS key %s: %w", key, err)
+		}
+	}
+
+	resp, err := sh.PublishWithDetails("/ipfs/"+cid, key, 0, 0, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to publish IPNS name: %w", err)
+	}
+
+	if _, err := db.ExecContext(ctx, `UPDATE published_epochs SET ipns_name = $1 WHERE epoch = $2`, resp.Name, epoch); err != nil {
+		return "", fmt.Errorf("failed to record IPNS name: %w", err)
+	}
+	return resp.Name, nil
+}
+
+const publishedEpochColumns = `epoch, cid, tokens, shards, ipns_name, published_at`
+
+func scanPublishedEpoch(row interface{ Scan(...interface{}) error }) (*PublishedEpoch, error) {
+	var p PublishedEpoch
+	var name sql.NullString
+	if err := row.Scan(&p.Epoch, &p.CID, &p.Tokens, &p.Shards, &name, &p.PublishedAt); err != nil {
+		return nil, err
+	}
+	if name.Valid {
+		p.IPNSName = &name.String
+	}
+	return &p, nil
+}
+
+// latestIPNSName returns the IPNS name most recently pointed at a snapshot, or "".
+func latestIPNSName() (string, error) {
+	var name string
+	err := db.QueryRow(`
+		SELECT ipns_name FROM published_epochs
+		WHERE ipns_name IS NOT NULL
+		ORDER BY epoch DESC LIMIT 1
+	`).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return name, err
+}
+
+func getPublishedEpochs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	page, limit, offset := parsePagination(r, 30)
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM published_epochs`).Scan(&total); err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Published epochs count error", "err", err)
+		return
+	}
+	ipnsName, err := latestIPNSName()
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("IPNS name query error", "err", err)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT `+publishedEpochColumns+`
+		FROM published_epochs
+		ORDER BY epoch DESC
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Published epochs query error", "err", err)
+		return
+	}
+	defer rows.Close()
+
+	published := []PublishedEpoch{}
+	for rows.Next() {
+		p, err := scanPublishedEpoch(rows)
+		if err != nil {
+			http.Error(w, "Row scan error", http.StatusInternalServerError)
+			loggerFrom(r.Context()).Error("Row scan error", "err", err)
+			return
+		}
+		published = append(published, *p)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Row iteration error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Row iteration error", "err", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"data":       published,
+		"ipns_name":  ipnsName,
+		"pagination": paginationMeta(total, page, limit),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
+
+// getPublishedEpoch serves /published/{epoch}, where epoch is a number or "latest".
+func getPublishedEpoch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	epoch := mux.Vars(r)["epoch"]
+
+	var row *sql.Row
+	if epoch == "latest" {
+		row = db.QueryRow(`SELECT ` + publishedEpochColumns + ` FROM published_epochs ORDER BY epoch DESC LIMIT 1`)
+	} else {
+		n, err := strconv.Atoi(epoch)
+		if err != nil {
+			http.Error(w, "Epoch must be a number or latest", http.StatusBadRequest)
+			return
+		}
+		row = db.QueryRow(`SELECT `+publishedEpochColumns+` FROM published_epochs WHERE epoch = $1`, n)
+	}
+
+	p, err := scanPublishedEpoch(row)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Epoch not published", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Published epoch query error", "err", err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"data": p}); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
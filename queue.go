@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Check queue priorities, highest first.
+const (
+	queuePriorityRequested = 100 // A user asked for the token to be re-checked
+	queuePriorityWatched   = 50  // Tokens flagged by an operator
+	queuePriorityMoved     = 20  // Ownership changed recently
+	queuePriorityNormal    = 0
+)
+
+const (
+	// queueBaseInterval is the delay before re-checking a token that just moved.
+	// Each consecutive check without an ownership change doubles it up to queueMaxInterval.
+	queueBaseInterval = 6 * time.Hour
+	queueMaxInterval  = 30 * 24 * time.Hour
+
+	// queueLease keeps a claimed token from being claimed again while it is being checked
+	queueLease = time.Hour
+
+	queueClaimSize   = 100
+	queueMaxPerRun   = 5000
+	queueConcurrency = 10
+)
+
+// QueueStats is the response of /admin/queue.
+type QueueStats struct {
+	Total        int            `json:"total"`
+	Due          int            `json:"due"`
+	Watched      int            `json:"watched"`
+	ByReason     map[string]int `json:"by_reason"`
+	LagSeconds   float64        `json:"lag_seconds"` // Age of the most overdue token
+	OldestDue    *time.Time     `json:"oldest_due"`
+	NextCheck    *time.Time     `json:"next_check"`
+	LastChecked  *time.Time     `json:"last_checked"`
+	AvgStability float64        `json:"avg_stable_checks"`
+}
+
+// nextCheckDelay returns how long to wait before re-checking a token that has been seen
+// unchanged stableChecks times in a row. Watched tokens never back off past the base interval.
+func nextCheckDelay(stableChecks int, watched bool) time.Duration {
+	if watched || stableChecks <= 0 {
+		return queueBaseInterval
+	}
+
+	delay := queueBaseInterval
+	for i := 0; i < stableChecks; i++ {
+		delay *= 2
+		if delay >= queueMaxInterval {
+			return queueMaxInterval
+		}
+	}
+	return delay
+}
+
+// enqueueMovedToken schedules a token whose ownership just changed, replacing any earlier
+// priority and retry time; only the watched flag carries over. Called from upsertTransaction.
+func enqueueMovedToken(tx *sql.Tx, tokenID string) error {
+	_, err := tx.Exec(`
+		INSERT INTO check_queue (token_id, priority, reason, stable_checks, next_check)
+		VALUES ($1, $2, 'moved', 0, NOW() + $3 * INTERVAL '1 second')
+		ON CONFLICT (token_id) DO UPDATE
+		SET priority = EXCLUDED.priority, reason = 'moved',
+			stable_checks = 0, next_check = EXCLUDED.next_check
+	`, tokenID, queuePriorityMoved, queueBaseInterval.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to enqueue moved token: %w", err)
+	}
+	return nil
+}
+
+// watchTokens flags the given tokens for frequent checks, skipping tokens missing from
+// token_info, and returns how many were flagged.
+func watchTokens(tokenIDs []string) (int64, error) {
+	res, err := db.Exec(`
+		INSERT INTO check_queue (token_id, priority, reason, watched, next_check)
+		SELECT token_id, $2, 'watched', TRUE, NOW()
+		FROM token_info
+		WHERE token_id = ANY($1)
+		ON CONFLICT (token_id) DO UPDATE
+		SET watched = TRUE, priority = GREATEST(check_queue.priority, EXCLUDED.priority)
+	`, pq.Array(tokenIDs), queuePriorityWatched)
+	if err != nil {
+		return 0, fmt.Errorf("failed to restore watched tokens: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// seedCheckQueue adds owned tokens that are not in the queue yet, due immediately.
+func seedCheckQueue() (int64, error) {
+	res, err := db.Exec(`
+		INSERT INTO check_queue (token_id, priority, reason, next_check)
+		SELECT token_id, $1, 'new', NOW()
+		FROM current_owners
+		ON CONFLICT (token_id) DO NOTHING
+	`, queuePriorityNormal)
+	if err != nil {
+		return 0, fmt.Errorf("failed to seed check queue: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+type queuedToken struct {
+	TokenID      string
+	StableChecks int
+	Watched      bool
+}
+
+// claimDueTokens leases the highest priority due tokens so they are not claimed twice.
+func claimDueTokens(ctx context.Context, limit int) ([]queuedToken, error) {
+	rows, err := db.QueryContext(ctx, `
+		UPDATE check_queue
+		SET next_check = NOW() + $2 * INTERVAL '1 second'
+		WHERE token_id IN (
+			SELECT token_id FROM check_queue
+			WHERE next_check <= NOW()
+			ORDER BY priority DESC, next_check
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING token_id, stable_checks, watched
+	`, limit, queueLease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim due tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []queuedToken
+	for rows.Next() {
+		var t queuedToken
+		if err := rows.Scan(&t.TokenID, &t.StableChecks, &t.Watched); err != nil {
+			return nil, fmt.Errorf("failed to scan queued token: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// recordCheckOutcome reschedules a token after checkPins ran. An ownership change was
+// already rescheduled by upsertTransaction through enqueueMovedToken.
+func recordCheckOutcome(t queuedToken, checkErr error) error {
+	if checkErr == nil {
+		return nil
+	}
+
+	// Interrupted by shutdown, release the lease so the token is picked up first next run
+	if errors.Is(checkErr, context.Canceled) {
+		_, err := db.Exec(`UPDATE check_queue SET next_check = NOW() WHERE token_id = $1`, t.TokenID)
+		return err
+	}
+
+	stable := t.StableChecks
+	reason := "error"
+	if errors.Is(checkErr, errNoOwnershipChange) {
+		stable++
+		reason = "stable"
+	}
+
+	priority := queuePriorityNormal
+	if t.Watched {
+		priority = queuePriorityWatched
+	}
+
+	_, err := db.Exec(`
+		UPDATE check_queue
+		SET priority = $1, reason = $2, stable_checks = $3, next_check = NOW() + $4 * INTERVAL '1 second'
+		WHERE token_id = $5
+	`, priority, reason, stable, nextCheckDelay(stable, t.Watched).Seconds(), t.TokenID)
+	if err != nil {
+		return fmt.Errorf("failed to reschedule token %s: %w", t.TokenID, err)
+	}
+	return nil
+}
+
+// runPinCheckQueue checks the pins of due tokens in priority order.
+func runPinCheckQueue(ctx context.Context) (JobResult, error) {
+	startTime := time.Now()
+	logger := loggerFrom(ctx)
+
+	if seeded, err := seedCheckQueue(); err != nil {
+		return JobResult{Errors: 1}, err
+	} else if seeded > 0 {
+		logger.Info("Added tokens to the check queue", "count", seeded)
+	}
+
+	// Semaphore for controlling concurrency
+	sem := make(chan struct{}, queueConcurrency)
+	var wg sync.WaitGroup
+	var processedCount int64
+	var errorCount int64
+
+	// Stop claiming new tokens on shutdown; claimed ones are checked or cancelled below
+	for processedCount < queueMaxPerRun && ctx.Err() == nil {
+		tokens, err := claimDueTokens(ctx, queueClaimSize)
+		if err != nil {
+			logger.Error("Check queue claim error", "err", err)
+			atomic.AddInt64(&errorCount, 1)
+			break
+		}
+		if len(tokens) == 0 {
+			break // Nothing left that is due
+		}
+
+		for _, t := range tokens {
+			wg.Add(1)
+			sem <- struct{}{} // Acquire semaphore slot
+			go func(t queuedToken) {
+				defer func() {
+					<-sem // Release semaphore slot
+					wg.Done()
+
+					// Recover from any panic in checkPins
+					if r := recover(); r != nil {
+						logger.Error("Recovered from panic in pin check", "token_id", t.TokenID, "panic", r)
+						atomic.AddInt64(&errorCount, 1)
+					}
+				}()
+
+				_, err := checkPins(ctx, t.TokenID)
+				if err != nil && !errors.Is(err, errNoOwnershipChange) && !errors.Is(err, context.Canceled) {
+					logger.Warn("Pin check failed", "token_id", t.TokenID, "err", err)
+					atomic.AddInt64(&errorCount, 1)
+				}
+				if err := recordCheckOutcome(t, err); err != nil {
+					logger.Error("Failed to record check outcome", "token_id", t.TokenID, "err", err)
+					atomic.AddInt64(&errorCount, 1)
+				}
+				atomic.AddInt64(&processedCount, 1)
+			}(t)
+		}
+
+		// Wait for the batch so processedCount bounds the run
+		wg.Wait()
+	}
+	close(sem)
+
+	logger.Info("Pin check queue run completed",
+		"tokens", processedCount, "errors", errorCount, "duration", time.Since(startTime))
+	return JobResult{Processed: processedCount, Errors: errorCount}, ctx.Err()
+}
+
+func fetchQueueStats() (*QueueStats, error) {
+	stats := QueueStats{ByReason: map[string]int{}}
+	var oldestDue, nextCheck, lastChecked sql.NullTime
+
+	err := db.QueryRow(`
+		SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE q.next_check <= NOW()),
+			COUNT(*) FILTER (WHERE q.watched),
+			MIN(q.next_check) FILTER (WHERE q.next_check <= NOW()),
+			MIN(q.next_check) FILTER (WHERE q.next_check > NOW()),
+			MAX(co.last_checked),
+			COALESCE(AVG(q.stable_checks), 0)
+		FROM check_queue q
+		LEFT JOIN current_owners co ON co.token_id = q.token_id
+	`).Scan(&stats.Total, &stats.Due, &stats.Watched, &oldestDue, &nextCheck, &lastChecked, &stats.AvgStability)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query queue stats: %w", err)
+	}
+
+	if oldestDue.Valid {
+		stats.OldestDue = &oldestDue.Time
+		stats.LagSeconds = time.Since(oldestDue.Time).Seconds()
+	}
+	if nextCheck.Valid {
+		stats.NextCheck = &nextCheck.Time
+	}
+	if lastChecked.Valid {
+		stats.LastChecked = &lastChecked.Time
+	}
+
+	rows, err := db.Query(`SELECT reason, COUNT(*) FROM check_queue GROUP BY reason`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query queue reasons: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var reason string
+		var count int
+		if err := rows.Scan(&reason, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan queue reason: %w", err)
+		}
+		stats.ByReason[reason] = count
+	}
+	return &stats, rows.Err()
+}
+
+func getQueueStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	stats, err := fetchQueueStats()
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Queue stats error", "err", err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
+
+// requestTokenCheck moves a token to the front of the check queue.
+func requestTokenCheck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	tokenID := vars["tokenID"]
+
+	if _, err := fetchTokenInfo(tokenID); err == sql.ErrNoRows {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Token info query error", "err", err)
+		return
+	}
+
+	var nextCheck time.Time
+	err := db.QueryRow(`
+		INSERT INTO check_queue (token_id, priority, reason, next_check)
+		VALUES ($1, $2, 'requested', NOW())
+		ON CONFLICT (token_id) DO UPDATE
+		SET priority = EXCLUDED.priority, reason = 'requested', next_check = LEAST(check_queue.next_check, NOW())
+		RETURNING next_check
+	`, tokenID, queuePriorityRequested).Scan(&nextCheck)
+	if err != nil {
+		http.Error(w, "Failed to queue token", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Queue request error", "err", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "queued", "next_check": nextCheck})
+}
+
+// setTokenWatched flags (PUT) or unflags (DELETE) a token for frequent checks.
+func setTokenWatched(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	tokenID := vars["tokenID"]
+	watched := r.Method == http.MethodPut
+
+	if _, err := fetchTokenInfo(tokenID); err == sql.ErrNoRows {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Token info query error", "err", err)
+		return
+	}
+
+	var err error
+	if watched {
+		_, err = db.Exec(`
+			INSERT INTO check_queue (token_id, priority, reason, watched, next_check)
+			VALUES ($1, $2, 'watched', TRUE, NOW())
+			ON CONFLICT (token_id) DO UPDATE
+			SET watched = TRUE, priority = GREATEST(check_queue.priority, EXCLUDED.priority)
+		`, tokenID, queuePriorityWatched)
+	} else {
+		// Drop the watched priority but keep a pending user request
+		_, err = db.Exec(`
+			UPDATE check_queue
+			SET watched = FALSE, priority = CASE WHEN priority = $2 THEN $3 ELSE priority END
+			WHERE token_id = $1
+		`, tokenID, queuePriorityWatched, queuePriorityNormal)
+	}
+	if err != nil {
+		http.Error(w, "Failed to update watch flag", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Watch update error", "err", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"token_id": tokenID, "watched": watched})
+}
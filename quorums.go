@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// quorumInactiveEpochs is how many epochs a pinner may miss before it counts as having stopped pinning.
+const quorumInactiveEpochs = 2
+
+// QuorumPinner summarizes how often a peer acted as an epoch pinner.
+type QuorumPinner struct {
+	PeerID         string  `json:"peer_id"`
+	Appearances    int     `json:"appearances"`
+	DistinctTokens int     `json:"distinct_tokens"`
+	ActiveEpochs   int     `json:"active_epochs"`
+	FirstEpoch     int     `json:"first_epoch"`
+	LastEpoch      int     `json:"last_epoch"`
+	StoppedPinning bool    `json:"stopped_pinning"`
+	OwnedTokens    int     `json:"owned_tokens"` // Tokens the pinner currently owns itself
+	OwnedValue     float64 `json:"owned_value"`
+}
+
+// QuorumEpochParticipation counts a pinner's appearances in one epoch.
+type QuorumEpochParticipation struct {
+	Epoch       int `json:"epoch"`
+	Appearances int `json:"appearances"`
+	Tokens      int `json:"tokens"`
+}
+
+// quorumPinnersQuery aggregates every quorum member across transactions. Ownership overlap
+// comes from the holder_balances materialized view.
+const quorumPinnersQuery = `
+	SELECT p.peer_id, p.appearances, p.distinct_tokens, p.active_epochs, p.first_epoch, p.last_epoch,
+		COALESCE(hb.token_count, 0) AS owned_tokens, COALESCE(hb.total_value, 0) AS owned_value
+	FROM (
+		SELECT q AS peer_id, COUNT(*) AS appearances, COUNT(DISTINCT t.token_id) AS distinct_tokens,
+			COUNT(DISTINCT t.epoch) AS active_epochs, MIN(t.epoch) AS first_epoch, MAX(t.epoch) AS last_epoch
+		FROM transactions t
+		CROSS JOIN LATERAL unnest(t.quorums) AS q
+		GROUP BY q
+	) p
+	LEFT JOIN holder_balances hb ON hb.peer_id = p.peer_id
+`
+
+func getQuorums(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	page, limit, offset := parsePagination(r, 30)
+
+	// ?status=active|inactive filters on whether the pinner stopped pinning
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "active" && status != "inactive" {
+		http.Error(w, "status must be active or inactive", http.StatusBadRequest)
+		return
+	}
+	cutoff := GetWeeksPassed() - quorumInactiveEpochs
+
+	// Counted separately so pages past the end still report the real total
+	const statusFilter = `$1 = '' OR ($1 = 'active') = (last_epoch >= $2)`
+	var total int
+	err := db.QueryRow(`SELECT COUNT(*) FROM (`+quorumPinnersQuery+`) pinners WHERE `+statusFilter, status, cutoff).Scan(&total)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Quorums count error", "err", err)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT *
+		FROM (`+quorumPinnersQuery+`) pinners
+		WHERE `+statusFilter+`
+		ORDER BY appearances DESC, peer_id
+		LIMIT $3 OFFSET $4
+	`, status, cutoff, limit, offset)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Quorums query error", "err", err)
+		return
+	}
+	defer rows.Close()
+
+	pinners := []QuorumPinner{}
+	for rows.Next() {
+		var p QuorumPinner
+		err := rows.Scan(&p.PeerID, &p.Appearances, &p.DistinctTokens, &p.ActiveEpochs,
+			&p.FirstEpoch, &p.LastEpoch, &p.OwnedTokens, &p.OwnedValue)
+		if err != nil {
+			http.Error(w, "DB row scan error", http.StatusInternalServerError)
+			loggerFrom(r.Context()).Error("Row scan error", "err", err)
+			return
+		}
+		p.StoppedPinning = p.LastEpoch < cutoff
+		pinners = append(pinners, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Rows iteration error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Rows iteration error", "err", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"data":       pinners,
+		"pagination": paginationMeta(total, page, limit),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
+
+func fetchQuorumParticipation(peerID string) ([]QuorumEpochParticipation, error) {
+	rows, err := db.Query(`
+		SELECT epoch, COUNT(*), COUNT(DISTINCT token_id)
+		FROM transactions
+		WHERE quorums @> ARRAY[$1]::text[]
+		GROUP BY epoch
+		ORDER BY epoch
+	`, peerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query quorum participation: %w", err)
+	}
+	defer rows.Close()
+
+	participation := []QuorumEpochParticipation{}
+	for rows.Next() {
+		var p QuorumEpochParticipation
+		if err := rows.Scan(&p.Epoch, &p.Appearances, &p.Tokens); err != nil {
+			return nil, fmt.Errorf("failed to scan quorum participation: %w", err)
+		}
+		participation = append(participation, p)
+	}
+	return participation, rows.Err()
+}
+
+func getQuorumByPeerID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	peerID := vars["peerID"]
+
+	participation, err := fetchQuorumParticipation(peerID)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Quorum participation query error", "err", err)
+		return
+	}
+
+	if len(participation) == 0 {
+		http.Error(w, "Peer "+peerID+" never acted as an epoch pinner", http.StatusNotFound)
+		return
+	}
+
+	pinner := QuorumPinner{PeerID: peerID, FirstEpoch: participation[0].Epoch, LastEpoch: participation[len(participation)-1].Epoch}
+	pinner.ActiveEpochs = len(participation)
+	for _, p := range participation {
+		pinner.Appearances += p.Appearances
+	}
+	pinner.StoppedPinning = pinner.LastEpoch < GetWeeksPassed()-quorumInactiveEpochs
+
+	err = db.QueryRow(`
+		SELECT COUNT(DISTINCT token_id) FROM transactions WHERE quorums @> ARRAY[$1]::text[]
+	`, peerID).Scan(&pinner.DistinctTokens)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Quorum tokens query error", "err", err)
+		return
+	}
+
+	holdings, err := fetchPeerHoldings(peerID)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Peer holdings query error", "err", err)
+		return
+	}
+	for _, h := range holdings {
+		pinner.OwnedTokens += h.Count
+		pinner.OwnedValue += h.TotalValue
+	}
+
+	response := map[string]interface{}{
+		"data":                  pinner,
+		"participation":         participation,
+		"inactive_after_epochs": quorumInactiveEpochs,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
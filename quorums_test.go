@@ -0,0 +1,48 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetQuorumsPagePastEnd(t *testing.T) {
+	var pageArgs []driver.Value
+	useFakeDB(t, func(query string, args []driver.Value) (fakeResult, error) {
+		if strings.Contains(query, "SELECT COUNT(*) FROM") {
+			return fakeResult{columns: []string{"count"}, rows: [][]driver.Value{{int64(3)}}}, nil
+		}
+		pageArgs = args
+		return fakeResult{columns: []string{"peer_id", "appearances", "distinct_tokens", "active_epochs",
+			"first_epoch", "last_epoch", "owned_tokens", "owned_value"}}, nil
+	})
+
+	rec := httptest.NewRecorder()
+	getQuorums(rec, httptest.NewRequest(http.MethodGet, "/quorums?page=5&limit=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	var resp struct {
+		Data       []QuorumPinner `json:"data"`
+		Pagination struct {
+			Total      int `json:"total"`
+			TotalPages int `json:"total_pages"`
+		} `json:"pagination"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data) != 0 {
+		t.Errorf("got %d pinners past the last page", len(resp.Data))
+	}
+	if resp.Pagination.Total != 3 || resp.Pagination.TotalPages != 2 {
+		t.Errorf("pagination total = %d, total_pages = %d, want 3 and 2", resp.Pagination.Total, resp.Pagination.TotalPages)
+	}
+	if len(pageArgs) != 4 || pageArgs[2] != int64(2) || pageArgs[3] != int64(8) {
+		t.Errorf("page query args = %v, want limit 2 offset 8", pageArgs)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Idle client limiters are dropped after this long; a returning client starts with a full bucket.
+const clientLimiterIdle = 30 * time.Minute
+
+func newLimiter(l RateLimit) *rate.Limiter {
+	if l.PerMinute <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(l.PerMinute/60), l.Burst)
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ClientLimiters keeps one token bucket per client.
+type ClientLimiters struct {
+	mu        sync.Mutex
+	limit     RateLimit
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+func NewClientLimiters(limit RateLimit) *ClientLimiters {
+	return &ClientLimiters{limit: limit, clients: make(map[string]*clientLimiter), lastSweep: time.Now()}
+}
+
+// Allow takes a token from the client's bucket and, when none is left, reports how long
+// until the next one.
+func (cl *ClientLimiters) Allow(client string) (bool, time.Duration) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(cl.lastSweep) > clientLimiterIdle {
+		for name, c := range cl.clients {
+			if now.Sub(c.lastSeen) > clientLimiterIdle {
+				delete(cl.clients, name)
+			}
+		}
+		cl.lastSweep = now
+	}
+
+	c, ok := cl.clients[client]
+	if !ok {
+		c = &clientLimiter{limiter: newLimiter(cl.limit)}
+		cl.clients[client] = c
+	}
+	c.lastSeen = now
+	return reserve(c.limiter)
+}
+
+// reserve takes a token if one is available now, without waiting for it.
+func reserve(l *rate.Limiter) (bool, time.Duration) {
+	r := l.Reserve()
+	if !r.OK() {
+		return false, time.Minute
+	}
+	if delay := r.Delay(); delay > 0 {
+		r.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// retryAfterSeconds rounds a delay up for the Retry-After header.
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Max(1, math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"decentralized-explorer-backend/ipfs"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Disagreement kinds stored in reconcile_diffs.
+const (
+	diffMismatch      = "mismatch"       // Both sides know the token but name different owners
+	diffMissingLocal  = "missing_local"  // Only the peer has an owner for the token
+	diffMissingRemote = "missing_remote" // Only we have an owner for the token
+)
+
+// ReconcileRun is one comparison against a peer explorer.
+type ReconcileRun struct {
+	RunID         int64      `json:"run_id"`
+	Peer          string     `json:"peer"`
+	Source        string     `json:"source"` // Export URL or snapshot CID that was compared
+	Status        string     `json:"status"` // running, succeeded or failed
+	Compared      int64      `json:"compared"`
+	Mismatches    int64      `json:"mismatches"`
+	MissingLocal  int64      `json:"missing_local"`
+	MissingRemote int64      `json:"missing_remote"`
+	Rechecked     int        `json:"rechecked"`
+	Resolved      int        `json:"resolved"`
+	Error         string     `json:"error,omitempty"`
+	StartedAt     time.Time  `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at"`
+}
+
+// ReconcileDiff is one token the peer disagrees with us on. Recheck is the checkPins
+// outcome when the token was re-checked, and Resolved whether we agree with the peer since.
+type ReconcileDiff struct {
+	TokenID       string   `json:"token_id"`
+	Kind          string   `json:"kind"`
+	LocalPeerIDs  []string `json:"local_peer_ids"`
+	RemotePeerIDs []string `json:"remote_peer_ids"`
+	Recheck       *string  `json:"recheck"`
+	Resolved      bool     `json:"resolved"`
+}
+
+// ownershipSource yields a peer's owners in token_id byte order, the order both
+// /export/current_owners and published epoch snapshots use.
+type ownershipSource interface {
+	Next() (OwnershipEntry, bool, error)
+	Close() error
+}
+
+// exportSource streams the peer's /export/current_owners as NDJSON.
+type exportSource struct {
+	resp *http.Response
+	dec  *json.Decoder
+	rows int64
+}
+
+func openExportSource(ctx context.Context, peer ReconcilePeer) (*exportSource, string, error) {
+	u := strings.TrimRight(peer.APIURL, "/") + "/export/current_owners?" + url.Values{"format": {"ndjson"}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, u, err
+	}
+	if peer.APIKey != "" {
+		req.Header.Set("X-API-Key", peer.APIKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, u, fmt.Errorf("failed to fetch %s: %w", u, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, u, fmt.Errorf("fetching %s returned %s", u, resp.Status)
+	}
+	return &exportSource{resp: resp, dec: json.NewDecoder(resp.Body)}, u, nil
+}
+
+func (s *exportSource) Next() (OwnershipEntry, bool, error) {
+	if !s.dec.More() {
+		return OwnershipEntry{}, false, s.complete()
+	}
+	var o exportCurrentOwner
+	if err := s.dec.Decode(&o); err != nil {
+		return OwnershipEntry{}, false, fmt.Errorf("failed to decode peer export: %w", err)
+	}
+	s.rows++
+	return OwnershipEntry{TokenID: o.TokenID, PeerIDs: o.PeerIDs, Timestamp: o.Timestamp}, true, nil
+}
+
+// complete checks the X-Export-Rows trailer at the end of the body. A peer whose export
+// fails part way still ends the body cleanly, and without this check every token after
+// the failure would be counted as missing_remote.
+func (s *exportSource) complete() error {
+	// Trailers are only available once the body has been read to EOF
+	if _, err := io.Copy(io.Discard, s.resp.Body); err != nil {
+		return fmt.Errorf("failed to read peer export: %w", err)
+	}
+	rows := s.resp.Trailer.Get("X-Export-Rows")
+	if rows == "" {
+		return errors.New("peer export ended without completing")
+	}
+	if n, err := strconv.ParseInt(rows, 10, 64); err != nil || n != s.rows {
+		return fmt.Errorf("peer export reported %s rows, received %d", rows, s.rows)
+	}
+	return nil
+}
+
+func (s *exportSource) Close() error { return s.resp.Body.Close() }
+
+// snapshotSource walks the shards of a peer's latest published epoch snapshot, fetching
+// one shard at a time.
+type snapshotSource struct {
+	root  epochSnapshot
+	shard int
+	items []OwnershipEntry
+}
+
+func openSnapshotSource(peer ReconcilePeer) (*snapshotSource, string, error) {
+	sh := ipfs.GetShell()
+	path, err := sh.Resolve(peer.IPNSName)
+	if err != nil {
+		return nil, peer.IPNSName, fmt.Errorf("failed to resolve %s: %w", peer.IPNSName, err)
+	}
+	cid := strings.TrimPrefix(path, "/ipfs/")
+
+	s := &snapshotSource{}
+	if err := sh.DagGet(cid, &s.root); err != nil {
+		return nil, cid, fmt.Errorf("failed to fetch snapshot %s: %w", cid, err)
+	}
+	if s.root.Type != epochSnapshotType {
+		return nil, cid, fmt.Errorf("%s is not an epoch ownership snapshot", cid)
+	}
+	return s, cid, nil
+}
+
+func (s *snapshotSource) Next() (OwnershipEntry, bool, error) {
+	for len(s.items) == 0 {
+		if s.shard >= len(s.root.Shards) {
+			return OwnershipEntry{}, false, nil
+		}
+		var shard ownershipShard
+		ref := s.root.Shards[s.shard].Data.CID
+		if err := ipfs.GetShell().DagGet(ref, &shard); err != nil {
+			return OwnershipEntry{}, false, fmt.Errorf("failed to fetch shard %s: %w", ref, err)
+		}
+		s.items = shard.Tokens
+		s.shard++
+	}
+	e := s.items[0]
+	s.items = s.items[1:]
+	return e, true, nil
+}
+
+func (s *snapshotSource) Close() error { return nil }
+
+// reconcileCounts tracks a run while the two sides are merged.
+type reconcileCounts struct {
+	compared, mismatches, missingLocal, missingRemote int64
+	stored                                            int
+}
+
+// runReconcile compares our current owners with every configured peer explorer.
+func runReconcile(ctx context.Context) (JobResult, error) {
+	var result JobResult
+	var errs []error
+	for _, peer := range cfg.Reconcile.Peers {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := reconcilePeer(ctx, peer); err != nil {
+			result.Errors++
+			errs = append(errs, fmt.Errorf("%s: %w", peer.Name, err))
+			continue
+		}
+		result.Processed++
+	}
+	return result, errors.Join(errs...)
+}
+
+// reconcilePeer merges the peer's owners with current_owners, both ordered by token_id,
+// and records every disagreement. For a snapshot, tokens we saw change hands after the
+// snapshot's epoch ended are skipped, since the peer cannot know about them yet.
+func reconcilePeer(ctx context.Context, peer ReconcilePeer) (retErr error) {
+	logger := loggerFrom(ctx).With("peer", peer.Name)
+
+	var runID int64
+	if err := db.QueryRowContext(ctx, `
+		INSERT INTO reconcile_runs (peer, status) VALUES ($1, 'running') RETURNING run_id
+	`, peer.Name).Scan(&runID); err != nil {
+		return fmt.Errorf("failed to record reconcile run: %w", err)
+	}
+
+	var counts reconcileCounts
+	var rechecked, resolved int
+	var sourceRef string
+	defer func() {
+		status, errText := "succeeded", sql.NullString{}
+		if retErr != nil {
+			status, errText = "failed", sql.NullString{String: retErr.Error(), Valid: true}
+		}
+		_, err := db.Exec(`
+			UPDATE reconcile_runs
+			SET status = $1, source = $2, compared = $3, mismatches = $4, missing_local = $5,
+				missing_remote = $6, rechecked = $7, resolved = $8, error = $9, finished_at = NOW()
+			WHERE run_id = $10
+		`, status, sourceRef, counts.compared, counts.mismatches, counts.missingLocal,
+			counts.missingRemote, rechecked, resolved, errText, runID)
+		if err != nil {
+			logger.Error("Failed to finish reconcile run", "run_id", runID, "err", err)
+		}
+	}()
+
+	var source ownershipSource
+	var asOf time.Time // Zero for live exports
+	var err error
+	if peer.IPNSName != "" {
+		var snap *snapshotSource
+		snap, sourceRef, err = openSnapshotSource(peer)
+		if snap != nil {
+			source, asOf = snap, snap.root.End
+		}
+	} else {
+		var export *exportSource
+		export, sourceRef, err = openExportSource(ctx, peer)
+		if export != nil {
+			source = export
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT token_id, peer_ids, timestamp
+		FROM current_owners
+		ORDER BY token_id COLLATE "C"
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query current owners: %w", err)
+	}
+	defer rows.Close()
+
+	nextLocal := func() (OwnershipEntry, bool, error) {
+		if !rows.Next() {
+			return OwnershipEntry{}, false, rows.Err()
+		}
+		var e OwnershipEntry
+		err := rows.Scan(&e.TokenID, pq.Array(&e.PeerIDs), &e.Timestamp)
+		return e, err == nil, err
+	}
+	// A peer sending tokens out of order would make every later token look missing
+	lastRemote := ""
+	nextRemote := func() (OwnershipEntry, bool, error) {
+		e, ok, err := source.Next()
+		if ok && e.TokenID <= lastRemote {
+			return e, false, fmt.Errorf("peer returned token %s out of order", e.TokenID)
+		}
+		lastRemote = e.TokenID
+		return e, ok, err
+	}
+	// A change we recorded after the snapshot's epoch ended is not a disagreement
+	newerThanSource := func(local OwnershipEntry) bool {
+		return !asOf.IsZero() && !local.Timestamp.Before(asOf)
+	}
+
+	local, hasLocal, err := nextLocal()
+	if err != nil {
+		return err
+	}
+	remote, hasRemote, err := nextRemote()
+	if err != nil {
+		return err
+	}
+
+	for hasLocal || hasRemote {
+		switch {
+		case hasLocal && (!hasRemote || local.TokenID < remote.TokenID):
+			if !newerThanSource(local) {
+				counts.missingRemote++
+				err = storeReconcileDiff(ctx, runID, &counts, local.TokenID, diffMissingRemote, local.PeerIDs, nil)
+			}
+			if err == nil {
+				local, hasLocal, err = nextLocal()
+			}
+		case !hasLocal || remote.TokenID < local.TokenID:
+			counts.missingLocal++
+			err = storeReconcileDiff(ctx, runID, &counts, remote.TokenID, diffMissingLocal, nil, remote.PeerIDs)
+			if err == nil {
+				remote, hasRemote, err = nextRemote()
+			}
+		default:
+			counts.compared++
+			if !comparePeers(local.PeerIDs, remote.PeerIDs) && !newerThanSource(local) {
+				counts.mismatches++
+				err = storeReconcileDiff(ctx, runID, &counts, local.TokenID, diffMismatch, local.PeerIDs, remote.PeerIDs)
+			}
+			if err == nil {
+				local, hasLocal, err = nextLocal()
+			}
+			if err == nil {
+				remote, hasRemote, err = nextRemote()
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	rows.Close()
+
+	reconcileDisagreements.WithLabelValues(peer.Name, diffMismatch).Set(float64(counts.mismatches))
+	reconcileDisagreements.WithLabelValues(peer.Name, diffMissingLocal).Set(float64(counts.missingLocal))
+	reconcileDisagreements.WithLabelValues(peer.Name, diffMissingRemote).Set(float64(counts.missingRemote))
+	logger.Info("Reconciled with peer explorer", "source", sourceRef, "compared", counts.compared,
+		"mismatches", counts.mismatches, "missing_local", counts.missingLocal, "missing_remote", counts.missingRemote)
+
+	if cfg.Reconcile.Recheck {
+		rechecked, resolved, err = recheckDisagreements(ctx, runID)
+		if err != nil {
+			return err
+		}
+		logger.Info("Re-checked disagreeing tokens", "rechecked", rechecked, "resolved", resolved)
+	}
+	return nil
+}
+
+// storeReconcileDiff records a disagreement, up to reconcile.max_stored_diffs per run.
+// The counts cover every disagreement either way.
+func storeReconcileDiff(ctx context.Context, runID int64, counts *reconcileCounts, tokenID, kind string, localPeers, remotePeers []string) error {
+	if counts.stored >= cfg.Reconcile.MaxStoredDiffs {
+		return nil
+	}
+	counts.stored++
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO reconcile_diffs (run_id, token_id, kind, local_peer_ids, remote_peer_ids)
+		VALUES ($1, $2, $3, $4, $5)
+	`, runID, tokenID, kind, pq.Array(nonNil(localPeers)), pq.Array(nonNil(remotePeers)))
+	if err != nil {
+		return fmt.Errorf("failed to store disagreement for %s: %w", tokenID, err)
+	}
+	return nil
+}
+
+// recheckDisagreements runs checkPins on up to reconcile.max_rechecks tokens the peer
+// claims a different or any owner for, so the DHT decides who is right. A token counts as
+// resolved when our owners match the peer's afterwards.
+func recheckDisagreements(ctx context.Context, runID int64) (rechecked, resolved int, err error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT token_id, remote_peer_ids
+		FROM reconcile_diffs
+		WHERE run_id = $1 AND kind IN ($2, $3)
+		ORDER BY token_id
+		LIMIT $4
+	`, runID, diffMismatch, diffMissingLocal, cfg.Reconcile.MaxRechecks)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to query disagreements: %w", err)
+	}
+	var diffs []ReconcileDiff
+	for rows.Next() {
+		var d ReconcileDiff
+		if err := rows.Scan(&d.TokenID, pq.Array(&d.RemotePeerIDs)); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("failed to scan disagreement: %w", err)
+		}
+		diffs = append(diffs, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	logger := loggerFrom(ctx)
+	for _, d := range diffs {
+		if ctx.Err() != nil {
+			return rechecked, resolved, ctx.Err()
+		}
+
+		outcome := "changed"
+		_, err := checkPins(ctx, d.TokenID)
+		if errors.Is(err, errNoOwnershipChange) {
+			outcome = "unchanged"
+		} else if err != nil {
+			outcome = "failed"
+			logger.Warn("Re-check of disagreeing token failed", "token_id", d.TokenID, "err", err)
+		}
+		rechecked++
+
+		var localPeers []string
+		err = db.QueryRowContext(ctx, `SELECT peer_ids FROM current_owners WHERE token_id = $1`, d.TokenID).Scan(pq.Array(&localPeers))
+		if err != nil && err != sql.ErrNoRows {
+			return rechecked, resolved, fmt.Errorf("failed to read owners of %s: %w", d.TokenID, err)
+		}
+		agrees := err == nil && comparePeers(localPeers, d.RemotePeerIDs)
+		if agrees {
+			resolved++
+		}
+
+		if _, err := db.ExecContext(ctx, `
+			UPDATE reconcile_diffs SET recheck = $1, resolved = $2 WHERE run_id = $3 AND token_id = $4
+		`, outcome, agrees, runID, d.TokenID); err != nil {
+			return rechecked, resolved, fmt.Errorf("failed to record re-check of %s: %w", d.TokenID, err)
+		}
+	}
+	return rechecked, resolved, nil
+}
+
+const reconcileRunColumns = `run_id, peer, COALESCE(source, ''), status, compared, mismatches, missing_local,
+	missing_remote, rechecked, resolved, COALESCE(error, ''), started_at, finished_at`
+
+func scanReconcileRun(scan func(dest ...interface{}) error) (*ReconcileRun, error) {
+	var run ReconcileRun
+	var finishedAt sql.NullTime
+	err := scan(&run.RunID, &run.Peer, &run.Source, &run.Status, &run.Compared, &run.Mismatches, &run.MissingLocal,
+		&run.MissingRemote, &run.Rechecked, &run.Resolved, &run.Error, &run.StartedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+	return &run, nil
+}
+
+func getReconcileRuns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	page, limit, offset := parsePagination(r, 20)
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM reconcile_runs`).Scan(&total); err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Reconcile runs count error", "err", err)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT `+reconcileRunColumns+`
+		FROM reconcile_runs
+		ORDER BY run_id DESC
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Reconcile runs query error", "err", err)
+		return
+	}
+	defer rows.Close()
+
+	runs := []ReconcileRun{}
+	for rows.Next() {
+		run, err := scanReconcileRun(rows.Scan)
+		if err != nil {
+			http.Error(w, "Row scan error", http.StatusInternalServerError)
+			loggerFrom(r.Context()).Error("Row scan error", "err", err)
+			return
+		}
+		runs = append(runs, *run)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Row iteration error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Row iteration error", "err", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"data":       runs,
+		"pagination": paginationMeta(total, page, limit),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
+
+// getReconcileRun returns a run with its disagreements, optionally filtered by ?kind=.
+func getReconcileRun(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	runID := mux.Vars(r)["runID"]
+	kind := r.URL.Query().Get("kind")
+	page, limit, offset := parsePagination(r, 50)
+
+	run, err := scanReconcileRun(db.QueryRow(`SELECT `+reconcileRunColumns+` FROM reconcile_runs WHERE run_id = $1`, runID).Scan)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Reconcile run not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Reconcile run query error", "err", err)
+		return
+	}
+
+	var total int
+	err = db.QueryRow(`SELECT COUNT(*) FROM reconcile_diffs WHERE run_id = $1 AND ($2 = '' OR kind = $2)`, runID, kind).Scan(&total)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Reconcile diffs count error", "err", err)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT token_id, kind, local_peer_ids, remote_peer_ids, recheck, resolved
+		FROM reconcile_diffs
+		WHERE run_id = $1 AND ($2 = '' OR kind = $2)
+		ORDER BY token_id
+		LIMIT $3 OFFSET $4
+	`, runID, kind, limit, offset)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Reconcile diffs query error", "err", err)
+		return
+	}
+	defer rows.Close()
+
+	diffs := []ReconcileDiff{}
+	for rows.Next() {
+		var d ReconcileDiff
+		var recheck sql.NullString
+		if err := rows.Scan(&d.TokenID, &d.Kind, pq.Array(&d.LocalPeerIDs), pq.Array(&d.RemotePeerIDs), &recheck, &d.Resolved); err != nil {
+			http.Error(w, "Row scan error", http.StatusInternalServerError)
+			loggerFrom(r.Context()).Error("Row scan error", "err", err)
+			return
+		}
+		if recheck.Valid {
+			d.Recheck = &recheck.String
+		}
+		diffs = append(diffs, d)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Row iteration error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Row iteration error", "err", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"data":       run,
+		"diffs":      diffs,
+		"pagination": paginationMeta(total, page, limit),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
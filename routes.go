@@ -3,47 +3,182 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// CORS middleware
-func enableCORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Allow all origins — for dev only, restrict in production!
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		// Handle preflight request
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+// enableCORS answers preflight requests and adds CORS headers for the origins allowed
+// in the config. Disallowed origins get no CORS headers, so browsers block the response.
+func enableCORS(c CORSConfig) func(http.Handler) http.Handler {
+	allowAny := false
+	origins := make(map[string]bool, len(c.AllowedOrigins))
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			allowAny = true
 		}
+		origins[strings.TrimSuffix(origin, "/")] = true
+	}
+	methods := strings.Join(c.AllowedMethods, ", ")
+	headers := strings.Join(c.AllowedHeaders, ", ")
+	exposed := strings.Join(c.ExposedHeaders, ", ")
 
-		next.ServeHTTP(w, r)
-	})
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+
+			allowed := origin != "" && (allowAny || origins[origin])
+			if allowed {
+				if allowAny && !c.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				} else {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+				if c.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+				if exposed != "" {
+					w.Header().Set("Access-Control-Expose-Headers", exposed)
+				}
+			}
+
+			// Handle preflight request
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				if allowed {
+					w.Header().Set("Access-Control-Allow-Methods", methods)
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+					if c.MaxAge > 0 {
+						w.Header().Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+					}
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// securityHeaders sets the response headers that harden browsers against sniffing,
+// framing and downgrades. HSTS is only sent on HTTPS requests, including those
+// terminated by a proxy that sets X-Forwarded-Proto.
+func securityHeaders(c SecurityConfig) func(http.Handler) http.Handler {
+	hsts := ""
+	if c.HSTSMaxAge > 0 {
+		hsts = fmt.Sprintf("max-age=%d; includeSubDomains", c.HSTSMaxAge)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("X-Frame-Options", "DENY")
+			h.Set("Referrer-Policy", "no-referrer")
+			if c.ContentSecurityPolicy != "" {
+				h.Set("Content-Security-Policy", c.ContentSecurityPolicy)
+			}
+			if hsts != "" && (r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https") {
+				h.Set("Strict-Transport-Security", hsts)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitBody rejects request bodies larger than maxBytes with 413 up front when the
+// length is declared, and caps reads otherwise.
+func limitBody(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if maxBytes > 0 {
+				if r.ContentLength > maxBytes {
+					http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
-func setupRoutes() *mux.Router {
+// setupRoutes returns the API router wrapped in the metrics, CORS, security header and body
+// limit middleware. They wrap the router rather than being registered with router.Use, so
+// they also apply to preflight requests and unmatched routes.
+func setupRoutes(c *Config) http.Handler {
 	router := mux.NewRouter()
+	router.Use(requestIDMiddleware)
+	router.Use(recordRoute)
+
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
 
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "Server is up and running 🚀")
 	})
 
+	// Plain-text summary of /readyz for existing monitors
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		if runHealthChecks(r.Context()).Status == checkFail {
+			http.Error(w, "UNAVAILABLE", http.StatusServiceUnavailable)
+			return
+		}
 		fmt.Fprintln(w, "OK")
 	})
+	router.HandleFunc("/healthz", getLiveness).Methods("GET")
+	router.HandleFunc("/readyz", getReadiness).Methods("GET")
 
 	router.HandleFunc("/current-tokens", getCurrentTokens).Methods("GET")
 	// router.HandleFunc("/token-info", getTokenInfo).Methods("GET")
 	router.HandleFunc("/token-updates/{tokenID}", getTransactionsByTokenID).Methods("GET")
 	router.HandleFunc("/current-tokens/{peerID}", getCurrentTokensByPeerID).Methods("GET")
+	router.HandleFunc("/peers/{peerID}", getPeerProfile).Methods("GET")
+	router.HandleFunc("/peers/{peerID}/transfers", getPeerTransfers).Methods("GET")
+	router.HandleFunc("/peers/{peerID}/holdings", getPeerHoldingsAt).Methods("GET")
 	router.HandleFunc("/token-info/{tokenID}", getTokenInfoByTokenID).Methods("GET")
+	router.HandleFunc("/tokens/{tokenID}", getTokenDetails).Methods("GET")
+	router.HandleFunc("/tokens/{tokenID}/transfers", getTokenTransfers).Methods("GET")
+	router.HandleFunc("/tokens/{tokenID}/owner", getTokenOwnerAt).Methods("GET")
+	router.HandleFunc("/tokens/{tokenID}/check", requireAuth(requestTokenCheck)).Methods("POST")
 	// router.HandleFunc("/transactions/upsert", upsertTransactionHandler).Methods("POST")
 	router.HandleFunc("/latesttoken", getLatestMintedToken).Methods("GET")
-	router.HandleFunc("/synctokenstate/{tokenID}", syncLatestTokenState).Methods("GET")
+	router.HandleFunc("/synctokenstate/{tokenID}", requireAuth(syncLatestTokenState)).Methods("POST")
+	router.HandleFunc("/synctokenstate/jobs/{jobID:[0-9]+}", requireAuth(getSyncJob)).Methods("GET")
+	router.HandleFunc("/stats/holders", getHolderStats).Methods("GET")
+	router.HandleFunc("/epochs", getEpochs).Methods("GET")
+	router.HandleFunc("/quorums", getQuorums).Methods("GET")
+	router.HandleFunc("/quorums/{peerID}", getQuorumByPeerID).Methods("GET")
+	router.HandleFunc("/epochs/{epoch}", getEpochByNumber).Methods("GET")
+	router.HandleFunc("/epochs/{epoch}/transactions", getEpochTransactions).Methods("GET")
+	router.HandleFunc("/stats/supply", getSupplyStats).Methods("GET")
+	router.HandleFunc("/stats/supply/history", getSupplyHistory).Methods("GET")
+	router.HandleFunc("/published", getPublishedEpochs).Methods("GET")
+	router.HandleFunc("/published/{epoch}", getPublishedEpoch).Methods("GET")
+	router.HandleFunc("/export/{table}", requireAuth(exportTableHandler)).Methods("GET")
+
+	admin := router.PathPrefix("/admin").Subrouter()
+	// A client certificate is an extra layer on top of credentials, never a replacement
+	admin.Use(requireClientCert(c.Server.TLS))
+	admin.Use(func(next http.Handler) http.Handler { return requireAuth(next.ServeHTTP) })
+	admin.HandleFunc("/jobs", getJobs).Methods("GET")
+	admin.HandleFunc("/jobs/{name}", getJobByName).Methods("GET")
+	admin.HandleFunc("/jobs/{name}/runs/{runID:[0-9]+}", getJobRun).Methods("GET")
+	admin.HandleFunc("/jobs/{name}/run", triggerJob).Methods("POST")
+	admin.HandleFunc("/queue", getQueueStats).Methods("GET")
+	admin.HandleFunc("/reconcile", getReconcileRuns).Methods("GET")
+	admin.HandleFunc("/reconcile/{runID:[0-9]+}", getReconcileRun).Methods("GET")
+	admin.HandleFunc("/queue/watch/{tokenID}", setTokenWatched).Methods("PUT", "DELETE")
 
-	return router
+	var handler http.Handler = router
+	handler = compressResponses(handler)
+	handler = limitBody(c.Security.MaxBodyBytes)(handler)
+	handler = enableCORS(c.CORS)(handler)
+	handler = securityHeaders(c.Security)(handler)
+	handler = metricsMiddleware(handler)
+	return handler
 }
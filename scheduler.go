@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+var errJobRunning = errors.New("job is already running")
+
+// JobResult is what a job reports back to the scheduler when it finishes.
+type JobResult struct {
+	Processed int64
+	Errors    int64
+}
+
+// JobFunc is the body of a background job.
+type JobFunc func(ctx context.Context) (JobResult, error)
+
+// JobRun is one persisted execution of a job.
+type JobRun struct {
+	RunID      int64      `json:"run_id"`
+	JobName    string     `json:"job_name"`
+	Trigger    string     `json:"trigger"` // "schedule" or "manual"
+	Status     string     `json:"status"`  // "running", "succeeded", "failed" or "skipped"
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Processed  int64      `json:"processed"`
+	Errors     int64      `json:"errors"`
+	Error      string     `json:"error,omitempty"`
+	Replica    string     `json:"replica,omitempty"`
+}
+
+// Job is a named JobFunc with a cron schedule.
+type Job struct {
+	Name     string
+	Spec     string
+	Enabled  bool
+	schedule cron.Schedule
+	run      JobFunc
+
+	mu      sync.Mutex
+	running bool
+	nextRun time.Time
+}
+
+// JobStatus is the admin view of a job.
+type JobStatus struct {
+	Name    string     `json:"name"`
+	Spec    string     `json:"schedule"`
+	Enabled bool       `json:"enabled"`
+	Running bool       `json:"running"`
+	NextRun *time.Time `json:"next_run"`
+	LastRun *JobRun    `json:"last_run"`
+}
+
+// Scheduler runs registered jobs on their cron schedules, never running the same job
+// twice at once, and records every run in the job_runs table.
+type Scheduler struct {
+	mu     sync.RWMutex
+	jobs   map[string]*Job
+	runCtx context.Context // Context of the current Start, used by manual runs too
+	wg     sync.WaitGroup  // In-flight runs
+}
+
+var scheduler = NewScheduler()
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{jobs: make(map[string]*Job), runCtx: context.Background()}
+}
+
+// Register adds a job using the schedule from its JobConfig.
+func (s *Scheduler) Register(name string, jc JobConfig, run JobFunc) error {
+	schedule, err := cron.ParseStandard(jc.Schedule)
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q for job %s: %w", jc.Schedule, name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[name] = &Job{Name: name, Spec: jc.Schedule, Enabled: jc.Enabled, schedule: schedule, run: run}
+	return nil
+}
+
+// Get returns the job registered under name.
+func (s *Scheduler) Get(name string) (*Job, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	job, ok := s.jobs[name]
+	return job, ok
+}
+
+// Jobs returns all registered jobs sorted by name.
+func (s *Scheduler) Jobs() []*Job {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs
+}
+
+// Start launches a scheduling loop for every enabled job. Cancelling ctx stops the
+// loops and is passed on to the running jobs.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	s.runCtx = ctx
+	s.mu.Unlock()
+
+	if err := failInterruptedRuns(ctx); err != nil {
+		slog.Error("Failed to close interrupted job runs", "err", err)
+	}
+
+	for _, job := range s.Jobs() {
+		if !job.Enabled {
+			slog.Info("Job is disabled", "job", job.Name)
+			continue
+		}
+		go s.loop(ctx, job)
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, job *Job) {
+	// Resume from the last persisted run so a restart does not reset the schedule.
+	// A run missed while the process was down fires immediately.
+	next := time.Now()
+	if last, err := lastJobRun(job.Name); err == nil {
+		next = job.schedule.Next(last.StartedAt)
+	} else if err != sql.ErrNoRows {
+		slog.Error("Failed to load last run of job", "job", job.Name, "err", err)
+	}
+
+	for {
+		job.mu.Lock()
+		job.nextRun = next
+		job.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if _, err := s.execute(ctx, job, "schedule", false); err != nil && err != errJobRunning {
+			slog.Error("Job failed to start", "job", job.Name, "err", err)
+		}
+		next = job.schedule.Next(time.Now())
+	}
+}
+
+// Trigger starts a manual run of the named job in the background and returns its run ID.
+func (s *Scheduler) Trigger(name string) (int64, error) {
+	job, ok := s.Get(name)
+	if !ok {
+		return 0, fmt.Errorf("unknown job %s", name)
+	}
+
+	s.mu.RLock()
+	ctx := s.runCtx
+	s.mu.RUnlock()
+	if ctx.Err() != nil {
+		return 0, fmt.Errorf("scheduler stopped: %w", ctx.Err())
+	}
+	return s.execute(ctx, job, "manual", true)
+}
+
+// Wait blocks until all in-flight job runs have returned.
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// execute runs job unless it is already running, in which case a skipped run is recorded.
+func (s *Scheduler) execute(ctx context.Context, job *Job, trigger string, async bool) (int64, error) {
+	job.mu.Lock()
+	if job.running {
+		job.mu.Unlock()
+		if _, err := recordSkippedRun(job.Name, trigger); err != nil {
+			slog.Error("Failed to record skipped run", "job", job.Name, "err", err)
+		}
+		slog.Warn("Job is still running, skipping run", "job", job.Name, "trigger", trigger)
+		return 0, errJobRunning
+	}
+	job.running = true
+	job.mu.Unlock()
+
+	runID, err := startJobRun(job.Name, trigger)
+	if err != nil {
+		job.mu.Lock()
+		job.running = false
+		job.mu.Unlock()
+		return 0, err
+	}
+
+	s.wg.Add(1)
+	runJob := func() {
+		defer s.wg.Done()
+		defer func() {
+			job.mu.Lock()
+			job.running = false
+			job.mu.Unlock()
+		}()
+
+		// Everything the job logs through loggerFrom(ctx) carries the run ID
+		logger := slog.Default().With("job", job.Name, "run_id", runID, "trigger", trigger)
+		ctx := withLogger(ctx, logger)
+
+		logger.Info("Job started")
+		start := time.Now()
+		result, runErr := safeRun(ctx, job)
+		status, err := finishJobRun(runID, result, runErr)
+		if err != nil {
+			logger.Error("Failed to record job run", "err", err)
+		}
+		observeJobRun(job.Name, start, status, result)
+
+		attrs := []any{"status", status, "processed", result.Processed, "errors", result.Errors, "duration", time.Since(start)}
+		if runErr != nil {
+			logger.Error("Job finished", append(attrs, "err", runErr)...)
+		} else {
+			logger.Info("Job finished", attrs...)
+		}
+	}
+
+	if async {
+		go runJob()
+	} else {
+		runJob()
+	}
+	return runID, nil
+}
+
+// safeRun calls the job body, converting a panic into an error.
+func safeRun(ctx context.Context, job *Job) (result JobResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.run(ctx)
+}
+
+// Status returns the admin view of a job.
+func (job *Job) Status() JobStatus {
+	job.mu.Lock()
+	status := JobStatus{Name: job.Name, Spec: job.Spec, Enabled: job.Enabled, Running: job.running}
+	if !job.nextRun.IsZero() {
+		next := job.nextRun
+		status.NextRun = &next
+	}
+	job.mu.Unlock()
+
+	if last, err := lastJobRun(job.Name); err == nil {
+		status.LastRun = last
+	} else if err != sql.ErrNoRows {
+		slog.Error("Failed to load last run of job", "job", job.Name, "err", err)
+	}
+	return status
+}
+
+func startJobRun(name, trigger string) (int64, error) {
+	var runID int64
+	err := db.QueryRow(`
+		INSERT INTO job_runs (job_name, trigger, status, started_at, replica)
+		VALUES ($1, $2, 'running', NOW(), $3)
+		RETURNING run_id
+	`, name, trigger, leader.ID()).Scan(&runID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record job start: %w", err)
+	}
+	return runID, nil
+}
+
+// failInterruptedRuns marks runs left 'running' by replicas that are gone as failed. A
+// leader whose lock session dropped may still be draining its runs, so only runs of
+// replicas that no longer hold their liveness lock are failed.
+func failInterruptedRuns(ctx context.Context) error {
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT COALESCE(replica, '') FROM job_runs WHERE status = 'running'`)
+	if err != nil {
+		return err
+	}
+	var replicas []string
+	for rows.Next() {
+		var replica string
+		if err := rows.Scan(&replica); err != nil {
+			rows.Close()
+			return err
+		}
+		replicas = append(replicas, replica)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, replica := range replicas {
+		// Runs recorded before replicas were tracked have no replica to ask
+		if replica != "" {
+			alive, err := leader.ReplicaAlive(ctx, replica)
+			if err != nil {
+				return err
+			}
+			if alive {
+				continue
+			}
+		}
+		res, err := db.ExecContext(ctx, `
+			UPDATE job_runs
+			SET status = 'failed', finished_at = NOW(), error = 'interrupted'
+			WHERE status = 'running' AND COALESCE(replica, '') = $1
+		`, replica)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			slog.Warn("Marked interrupted job runs as failed", "replica", replica, "count", n)
+		}
+	}
+	return nil
+}
+
+func recordSkippedRun(name, trigger string) (int64, error) {
+	var runID int64
+	err := db.QueryRow(`
+		INSERT INTO job_runs (job_name, trigger, status, started_at, finished_at, replica)
+		VALUES ($1, $2, 'skipped', NOW(), NOW(), $3)
+		RETURNING run_id
+	`, name, trigger, leader.ID()).Scan(&runID)
+	return runID, err
+}
+
+// finishJobRun records the outcome of a run and returns its final status.
+func finishJobRun(runID int64, result JobResult, runErr error) (string, error) {
+	status := "succeeded"
+	var errMsg sql.NullString
+	if runErr != nil {
+		status = "failed"
+		errMsg = sql.NullString{String: runErr.Error(), Valid: true}
+	}
+
+	// A new leader may have failed the run as interrupted meanwhile; that record stands
+	res, err := db.Exec(`
+		UPDATE job_runs
+		SET status = $1, finished_at = NOW(), processed = $2, errors = $3, error = $4
+		WHERE run_id = $5 AND status = 'running'
+	`, status, result.Processed, result.Errors, errMsg, runID)
+	if err != nil {
+		return status, fmt.Errorf("failed to record job finish: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return status, fmt.Errorf("run %d was already closed as interrupted", runID)
+	}
+	return status, nil
+}
+
+const jobRunColumns = `run_id, job_name, trigger, status, started_at, finished_at, processed, errors, error, replica`
+
+func scanJobRun(scan func(dest ...interface{}) error) (*JobRun, error) {
+	var run JobRun
+	var finishedAt sql.NullTime
+	var errMsg, replica sql.NullString
+	err := scan(&run.RunID, &run.JobName, &run.Trigger, &run.Status, &run.StartedAt, &finishedAt, &run.Processed, &run.Errors, &errMsg, &replica)
+	if err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+	run.Error = errMsg.String
+	run.Replica = replica.String
+	return &run, nil
+}
+
+// lastJobRun returns the most recent non-skipped run of a job.
+func lastJobRun(name string) (*JobRun, error) {
+	row := db.QueryRow(`
+		SELECT `+jobRunColumns+`
+		FROM job_runs
+		WHERE job_name = $1 AND status <> 'skipped'
+		ORDER BY started_at DESC
+		LIMIT 1
+	`, name)
+	return scanJobRun(row.Scan)
+}
+
+func fetchJobRuns(name string, limit, offset int) ([]JobRun, int, error) {
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM job_runs WHERE job_name = $1`, name).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count job runs: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT `+jobRunColumns+`
+		FROM job_runs
+		WHERE job_name = $1
+		ORDER BY started_at DESC
+		LIMIT $2 OFFSET $3
+	`, name, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query job runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []JobRun{}
+	for rows.Next() {
+		run, err := scanJobRun(rows.Scan)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan job run: %w", err)
+		}
+		runs = append(runs, *run)
+	}
+	return runs, total, rows.Err()
+}
+
+// registerJobs wires the explorer's background jobs into the scheduler.
+func registerJobs(s *Scheduler, c *Config) error {
+	jobs := map[string]JobFunc{
+		"weekly-sync":     runWeeklySync,
+		"pin-check-queue": runPinCheckQueue,
+		"stats-refresh":   runStatsRefresh,
+		"supply-snapshot": runSupplySnapshot,
+		"state-publish":   runStatePublish,
+		"reconcile":       runReconcile,
+	}
+
+	for name, run := range jobs {
+		jc, ok := c.Jobs[name]
+		if !ok {
+			return fmt.Errorf("no configuration for job %s", name)
+		}
+		if err := s.Register(name, jc, run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
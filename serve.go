@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// runServe implements `serve`: it runs the API and, on the leader replica, the background
+// jobs until ctx is cancelled, then drains both.
+func runServe(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", cfg.Server.Addr, "listen address, overrides server.addr")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg.Server.Addr = *addr
+
+	// Cancelled on shutdown or when the listener fails; jobs and syncs derive from it
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	registerDBMetrics()
+
+	if err := backfillTransfers(); err != nil {
+		slog.Error("Transfers backfill failed", "err", err)
+	}
+
+	responseCache = NewLRUCache(cfg.Cache.Size, time.Duration(cfg.Cache.TTLSeconds)*time.Second)
+
+	// On-demand token syncs are cancelled on shutdown like the jobs
+	syncer = NewTokenSyncer(ctx, cfg.Sync)
+	handler := setupRoutes(cfg)
+
+	var certs *CertReloader
+	if cfg.Server.TLS.Enabled {
+		var err error
+		if certs, err = NewCertReloader(cfg.Server.TLS); err != nil {
+			return fmt.Errorf("TLS setup failed: %w", err)
+		}
+	}
+
+	// Periodic background jobs (weekly token sync, pin-check queue, stats) run on
+	// the schedules from config.json
+	if err := registerJobs(scheduler, cfg); err != nil {
+		return fmt.Errorf("job registration failed: %w", err)
+	}
+
+	// Only the replica holding the leader lock runs the jobs; all replicas serve the API
+	leader = NewLeaderElector(db)
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		leader.Run(ctx, scheduler.Start, scheduler.Wait)
+	}()
+
+	server := &http.Server{
+		Addr:    cfg.Server.Addr,
+		Handler: handler,
+	}
+
+	serverErr := make(chan error, 2)
+	var redirectServer *http.Server
+	if certs != nil {
+		go certs.Watch(ctx)
+		server.TLSConfig = certs.TLSConfig()
+
+		if addr := cfg.Server.TLS.RedirectAddr; addr != "" {
+			redirectServer = &http.Server{Addr: addr, Handler: redirectToHTTPS(server.Addr)}
+			go func() {
+				slog.Info("HTTPS redirect started", "addr", redirectServer.Addr)
+				if err := redirectServer.ListenAndServe(); err != http.ErrServerClosed {
+					serverErr <- err
+				}
+			}()
+		}
+	}
+
+	go func() {
+		slog.Info("Server started", "addr", server.Addr, "tls", server.TLSConfig != nil)
+		if server.TLSConfig != nil {
+			serverErr <- server.ListenAndServeTLS("", "")
+		} else {
+			serverErr <- server.ListenAndServe()
+		}
+	}()
+
+	var err error
+	select {
+	case err = <-serverErr:
+		err = fmt.Errorf("server error: %w", err)
+		stop()
+	case <-ctx.Done():
+		drain := time.Duration(cfg.Server.ShutdownDrainSeconds) * time.Second
+		slog.Info("Shutdown signal received, draining", "delay", drain)
+		// Fail readiness first and keep serving until load balancers have noticed
+		shuttingDown.Store(true)
+		select {
+		case <-time.After(drain):
+		case err = <-serverErr:
+			err = fmt.Errorf("server error: %w", err)
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("HTTP server shutdown error", "err", err)
+	}
+	if redirectServer != nil {
+		redirectServer.Shutdown(shutdownCtx)
+	}
+
+	syncer.Wait()
+
+	// Leader.Run returns after in-flight jobs have drained and the leader lock is released
+	<-leaderDone
+	slog.Info("Background jobs stopped")
+	return err
+}
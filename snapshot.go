@@ -0,0 +1,423 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"decentralized-explorer-backend/ipfs"
+
+	"github.com/lib/pq"
+)
+
+const (
+	snapshotFormatVersion = 1
+	snapshotManifestName  = "manifest.json"
+)
+
+// snapshotTables lists the tables a snapshot carries, in the order they are loaded so
+// foreign keys to token_info are satisfied.
+var snapshotTables = []string{"token_info", "current_owners", "transactions"}
+
+// SnapshotManifest describes a snapshot directory. The signature covers the JSON encoding
+// of the manifest with Signature left empty, and the file checksums tie the data to it.
+type SnapshotManifest struct {
+	FormatVersion int            `json:"format_version"`
+	SchemaVersion int            `json:"schema_version"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Files         []SnapshotFile `json:"files"`
+	PublicKey     string         `json:"public_key,omitempty"` // Base64 ed25519 key of the signer
+	Signature     string         `json:"signature,omitempty"`
+}
+
+// SnapshotFile is one gzipped NDJSON table dump.
+type SnapshotFile struct {
+	Table  string `json:"table"`
+	Name   string `json:"name"`
+	Rows   int64  `json:"rows"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+func (m *SnapshotManifest) signedPayload() ([]byte, error) {
+	unsigned := *m
+	unsigned.Signature = ""
+	return json.Marshal(unsigned)
+}
+
+// loadSigningKey reads an ed25519 private key in PKCS#8 PEM form, as written by
+// `openssl genpkey -algorithm ed25519`.
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not an ed25519 key", path)
+	}
+	return edKey, nil
+}
+
+// createSnapshot dumps the snapshot tables into dir from one repeatable-read transaction,
+// so current_owners and transactions agree, then writes the signed manifest.
+func createSnapshot(ctx context.Context, dir string, key ed25519.PrivateKey) (*SnapshotManifest, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin snapshot transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	manifest := &SnapshotManifest{
+		FormatVersion: snapshotFormatVersion,
+		SchemaVersion: schemaVersion,
+		CreatedAt:     time.Now().UTC(),
+	}
+	for _, name := range snapshotTables {
+		file, err := writeSnapshotTable(ctx, tx, exportTables[name], dir)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	manifest.PublicKey = base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	payload, err := manifest.signedPayload()
+	if err != nil {
+		return nil, err
+	}
+	manifest.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, snapshotManifestName), data, 0644); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func writeSnapshotTable(ctx context.Context, tx *sql.Tx, table exportTable, dir string) (SnapshotFile, error) {
+	file := SnapshotFile{Table: table.name, Name: table.name + ".ndjson.gz"}
+	out, err := os.Create(filepath.Join(dir, file.Name))
+	if err != nil {
+		return file, err
+	}
+	defer out.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(out, hash)}
+	gz := gzip.NewWriter(counter)
+
+	rows, _, err := runExport(ctx, tx, table, "ndjson", ExportFilter{}, gz)
+	if err != nil {
+		return file, fmt.Errorf("failed to dump %s: %w", table.name, err)
+	}
+	if err := gz.Close(); err != nil {
+		return file, err
+	}
+	if err := out.Close(); err != nil {
+		return file, err
+	}
+
+	file.Rows = int64(rows)
+	file.Bytes = counter.n
+	file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return file, nil
+}
+
+// publishSnapshot adds the snapshot directory to the local IPFS node and returns its CID.
+func publishSnapshot(dir string) (string, error) {
+	cid, err := ipfs.GetShell().AddDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to add snapshot to IPFS: %w", err)
+	}
+	return cid, nil
+}
+
+// fetchSnapshot downloads a published snapshot into dest, which must not exist yet.
+func fetchSnapshot(cid, dest string) error {
+	if err := ipfs.GetShell().Get(cid, dest); err != nil {
+		return fmt.Errorf("failed to fetch snapshot %s from IPFS: %w", cid, err)
+	}
+	return nil
+}
+
+// verifySnapshot reads the manifest in dir and checks its signature against the trusted
+// keys and every file against its checksum. allowUnsigned skips only the signature check.
+func verifySnapshot(dir string, trustedKeys []string, allowUnsigned bool) (*SnapshotManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, snapshotManifestName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var manifest SnapshotManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	if manifest.FormatVersion != snapshotFormatVersion {
+		return nil, fmt.Errorf("unsupported snapshot format version %d", manifest.FormatVersion)
+	}
+	// Older snapshots load fine as long as the snapshot tables keep their columns
+	if manifest.SchemaVersion > schemaVersion {
+		return nil, fmt.Errorf("snapshot has schema version %d, newer than this build's %d", manifest.SchemaVersion, schemaVersion)
+	}
+
+	if err := verifyManifestSignature(&manifest, trustedKeys); err != nil {
+		if !allowUnsigned {
+			return nil, err
+		}
+		slog.Warn("Importing snapshot without a trusted signature", "err", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, file := range manifest.Files {
+		if _, ok := exportTables[file.Table]; !ok || seen[file.Table] {
+			return nil, fmt.Errorf("manifest lists unexpected table %q", file.Table)
+		}
+		seen[file.Table] = true
+		if filepath.Base(file.Name) != file.Name {
+			return nil, fmt.Errorf("manifest file name %q must not contain a path", file.Name)
+		}
+		if err := verifySnapshotFile(filepath.Join(dir, file.Name), file); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range snapshotTables {
+		if !seen[name] {
+			return nil, fmt.Errorf("snapshot is missing table %s", name)
+		}
+	}
+	return &manifest, nil
+}
+
+func verifyManifestSignature(m *SnapshotManifest, trustedKeys []string) error {
+	if m.Signature == "" {
+		return errors.New("snapshot is not signed")
+	}
+	trusted := false
+	for _, key := range trustedKeys {
+		if key == m.PublicKey {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return fmt.Errorf("snapshot signer %s is not in snapshot.trusted_keys", m.PublicKey)
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(m.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return errors.New("snapshot public key is malformed")
+	}
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return errors.New("snapshot signature is malformed")
+	}
+	payload, err := m.signedPayload()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, payload, signature) {
+		return errors.New("snapshot signature does not match the manifest")
+	}
+	return nil
+}
+
+func verifySnapshotFile(path string, file SnapshotFile) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, f)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file.Name, err)
+	}
+	if n != file.Bytes || hex.EncodeToString(hash.Sum(nil)) != file.SHA256 {
+		return fmt.Errorf("%s does not match its checksum in the manifest", file.Name)
+	}
+	return nil
+}
+
+// snapshotLoaders decode one NDJSON line of a table into the values of its COPY columns.
+var snapshotLoaders = map[string]struct {
+	columns []string
+	decode  func(dec *json.Decoder) ([]interface{}, error)
+}{
+	"token_info": {
+		columns: []string{"token_id", "token_level", "token_number", "token_value", "token_type", "parent_token_id"},
+		decode: func(dec *json.Decoder) ([]interface{}, error) {
+			var t exportTokenInfo
+			err := dec.Decode(&t)
+			return []interface{}{t.TokenID, t.TokenLevel, t.TokenNumber, t.TokenValue, t.TokenType, t.ParentTokenID}, err
+		},
+	},
+	"current_owners": {
+		columns: []string{"token_id", "peer_ids", "epoch", "quorums", "timestamp", "last_checked"},
+		decode: func(dec *json.Decoder) ([]interface{}, error) {
+			var o exportCurrentOwner
+			err := dec.Decode(&o)
+			return []interface{}{o.TokenID, pq.Array(nonNil(o.PeerIDs)), o.Epoch, pq.Array(nonNil(o.Quorums)), o.Timestamp, o.LastChecked}, err
+		},
+	},
+	"transactions": {
+		columns: []string{"tx_id", "token_id", "peer_ids", "epoch", "quorums", "timestamp"},
+		decode: func(dec *json.Decoder) ([]interface{}, error) {
+			var t exportTransaction
+			err := dec.Decode(&t)
+			return []interface{}{t.TxID, t.TokenID, pq.Array(nonNil(t.PeerIDs)), t.Epoch, pq.Array(nonNil(t.Quorums)), t.Timestamp}, err
+		},
+	},
+}
+
+// nonNil keeps empty lists from being stored as NULL in NOT NULL array columns.
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// importSnapshot loads a verified snapshot in one transaction. The target tables must be
+// empty unless replace is set, in which case they and the data derived from them are
+// truncated first; watched tokens that the snapshot still holds stay watched.
+func importSnapshot(ctx context.Context, dir string, manifest *SnapshotManifest, replace bool) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin import transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var watched []string
+	if replace {
+		// Watch flags set through the admin API are not part of the snapshot, keep them
+		err := tx.QueryRowContext(ctx, `SELECT COALESCE(array_agg(token_id), '{}') FROM check_queue WHERE watched`).Scan(pq.Array(&watched))
+		if err != nil {
+			return fmt.Errorf("failed to read watched tokens: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `TRUNCATE token_info, current_owners, transactions, transfers, check_queue`); err != nil {
+			return fmt.Errorf("failed to clear tables: %w", err)
+		}
+	} else {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM token_info)`).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return errors.New("database already holds tokens; use -replace to overwrite it")
+		}
+	}
+	// Imported tx_ids can be below the last backfill mark, make the next backfill scan them
+	if _, err := tx.ExecContext(ctx, `DELETE FROM transfers_backfill`); err != nil {
+		return fmt.Errorf("failed to reset transfers backfill: %w", err)
+	}
+
+	files := make(map[string]SnapshotFile)
+	for _, file := range manifest.Files {
+		files[file.Table] = file
+	}
+	for _, name := range snapshotTables {
+		file := files[name]
+		count, err := copySnapshotTable(ctx, tx, filepath.Join(dir, file.Name), name)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", name, err)
+		}
+		if count != file.Rows {
+			return fmt.Errorf("%s holds %d rows, the manifest says %d", file.Name, count, file.Rows)
+		}
+		loggerFrom(ctx).Info("Snapshot table loaded", "table", name, "rows", count)
+	}
+
+	// tx_id values came from the snapshot, so move the sequence past them
+	if _, err := tx.ExecContext(ctx, `SELECT setval(pg_get_serial_sequence('transactions', 'tx_id'), COALESCE(MAX(tx_id), 0) + 1, false) FROM transactions`); err != nil {
+		return fmt.Errorf("failed to reset transaction sequence: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit import: %w", err)
+	}
+
+	// Transfers and the pin-check queue are derived from the imported rows
+	if err := backfillTransfers(); err != nil {
+		return err
+	}
+	if _, err := seedCheckQueue(); err != nil {
+		return err
+	}
+	if len(watched) > 0 {
+		restored, err := watchTokens(watched)
+		if err != nil {
+			return err
+		}
+		loggerFrom(ctx).Info("Watched tokens restored", "restored", restored, "dropped", int64(len(watched))-restored)
+	}
+	return nil
+}
+
+func copySnapshotTable(ctx context.Context, tx *sql.Tx, path, table string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+
+	loader := snapshotLoaders[table]
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(table, loader.columns...))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	dec := json.NewDecoder(gz)
+	var count int64
+	for dec.More() {
+		values, err := loader.decode(dec)
+		if err != nil {
+			return count, fmt.Errorf("row %d: %w", count+1, err)
+		}
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			return count, err
+		}
+		count++
+	}
+	// The final Exec without arguments flushes the COPY
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return count, err
+	}
+	return count, nil
+}
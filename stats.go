@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Holder is one entry of the rich list. A token pinned by several peers counts
+// fully towards each of them, mirroring how /current-tokens/{peerID} reports holdings.
+type Holder struct {
+	PeerID     string  `json:"peer_id"`
+	TokenCount int     `json:"token_count"`
+	TotalValue float64 `json:"total_value"`
+}
+
+// LevelDistribution summarizes owned tokens of one token_level.
+type LevelDistribution struct {
+	TokenLevel  int     `json:"token_level"`
+	TokenCount  int     `json:"token_count"`
+	TotalValue  float64 `json:"total_value"`
+	HolderCount int     `json:"holder_count"`
+}
+
+// HolderStats is the response of /stats/holders.
+type HolderStats struct {
+	DistinctHolders int                 `json:"distinct_holders"`
+	TotalValue      float64             `json:"total_value"`
+	Gini            float64             `json:"gini"`
+	Top10Share      float64             `json:"top10_share"`
+	TopByCount      []Holder            `json:"top_by_count"`
+	TopByValue      []Holder            `json:"top_by_value"`
+	ByLevel         []LevelDistribution `json:"by_level"`
+	RefreshedAt     *time.Time          `json:"refreshed_at"`
+}
+
+var (
+	// statsDirty is set whenever ownership changes so the next refresh tick rebuilds the views
+	statsDirty atomic.Bool
+
+	statsRefreshMu     sync.RWMutex
+	statsRefreshedTime time.Time
+)
+
+func markStatsDirty() {
+	statsDirty.Store(true)
+}
+
+// refreshHolderStats rebuilds the materialized views behind /stats/holders.
+func refreshHolderStats() error {
+	for _, view := range []string{"holder_balances", "level_distribution"} {
+		if _, err := db.Exec("REFRESH MATERIALIZED VIEW CONCURRENTLY " + view); err != nil {
+			return fmt.Errorf("failed to refresh %s: %w", view, err)
+		}
+	}
+
+	statsRefreshMu.Lock()
+	statsRefreshedTime = time.Now()
+	statsRefreshMu.Unlock()
+	return nil
+}
+
+// runStatsRefresh refreshes the holder statistics, but only when upsertTransaction recorded
+// an ownership change since the previous refresh or the views were never refreshed by this process.
+func runStatsRefresh(ctx context.Context) (JobResult, error) {
+	statsRefreshMu.RLock()
+	neverRefreshed := statsRefreshedTime.IsZero()
+	statsRefreshMu.RUnlock()
+
+	if !statsDirty.Swap(false) && !neverRefreshed {
+		return JobResult{}, nil
+	}
+
+	if err := refreshHolderStats(); err != nil {
+		markStatsDirty()
+		return JobResult{Errors: 1}, err
+	}
+	return JobResult{Processed: 1}, nil
+}
+
+// giniCoefficient computes the Gini coefficient of n values from their sum and the
+// sum of each value weighted by its 1-based rank in ascending order.
+func giniCoefficient(n int, sum, rankWeightedSum float64) float64 {
+	if n == 0 || sum == 0 {
+		return 0
+	}
+	fn := float64(n)
+	return (2*rankWeightedSum)/(fn*sum) - (fn+1)/fn
+}
+
+func fetchTopHolders(orderBy string, limit int) ([]Holder, error) {
+	rows, err := db.Query(`
+		SELECT peer_id, token_count, total_value
+		FROM holder_balances
+		ORDER BY `+orderBy+` DESC, peer_id
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query top holders: %w", err)
+	}
+	defer rows.Close()
+
+	holders := []Holder{}
+	for rows.Next() {
+		var h Holder
+		if err := rows.Scan(&h.PeerID, &h.TokenCount, &h.TotalValue); err != nil {
+			return nil, fmt.Errorf("failed to scan holder: %w", err)
+		}
+		holders = append(holders, h)
+	}
+	return holders, rows.Err()
+}
+
+func fetchLevelDistribution() ([]LevelDistribution, error) {
+	rows, err := db.Query(`
+		SELECT token_level, token_count, total_value, holder_count
+		FROM level_distribution
+		ORDER BY token_level
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query level distribution: %w", err)
+	}
+	defer rows.Close()
+
+	levels := []LevelDistribution{}
+	for rows.Next() {
+		var l LevelDistribution
+		if err := rows.Scan(&l.TokenLevel, &l.TokenCount, &l.TotalValue, &l.HolderCount); err != nil {
+			return nil, fmt.Errorf("failed to scan level distribution: %w", err)
+		}
+		levels = append(levels, l)
+	}
+	return levels, rows.Err()
+}
+
+func computeHolderStats(limit int) (*HolderStats, error) {
+	var stats HolderStats
+	var rankWeightedSum, top10Value float64
+
+	err := db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(total_value), 0),
+			COALESCE(SUM(rank_asc * total_value), 0),
+			COALESCE(SUM(total_value) FILTER (WHERE rank_desc <= 10), 0)
+		FROM (
+			SELECT total_value,
+				ROW_NUMBER() OVER (ORDER BY total_value ASC) AS rank_asc,
+				ROW_NUMBER() OVER (ORDER BY total_value DESC) AS rank_desc
+			FROM holder_balances
+		) ranked
+	`).Scan(&stats.DistinctHolders, &stats.TotalValue, &rankWeightedSum, &top10Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute concentration: %w", err)
+	}
+
+	stats.Gini = giniCoefficient(stats.DistinctHolders, stats.TotalValue, rankWeightedSum)
+	if stats.TotalValue > 0 {
+		stats.Top10Share = top10Value / stats.TotalValue
+	}
+
+	if stats.TopByCount, err = fetchTopHolders("token_count", limit); err != nil {
+		return nil, err
+	}
+	if stats.TopByValue, err = fetchTopHolders("total_value", limit); err != nil {
+		return nil, err
+	}
+	if stats.ByLevel, err = fetchLevelDistribution(); err != nil {
+		return nil, err
+	}
+
+	statsRefreshMu.RLock()
+	if !statsRefreshedTime.IsZero() {
+		refreshedAt := statsRefreshedTime
+		stats.RefreshedAt = &refreshedAt
+	}
+	statsRefreshMu.RUnlock()
+
+	return &stats, nil
+}
+
+func getHolderStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	limit := 20 // default
+	if val := r.URL.Query().Get("limit"); val != "" {
+		if l, err := strconv.Atoi(val); err == nil && l > 0 {
+			if l > 100 {
+				l = 100
+			}
+			limit = l
+		}
+	}
+
+	stats, err := computeHolderStats(limit)
+	if err != nil {
+		http.Error(w, "Failed to compute holder stats", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Holder stats error", "err", err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// jsonList streams a {"data":[...], ...} response, encoding each row as it is scanned
+// instead of collecting the page in a slice first. Nothing is written before the first
+// item or Close, so an error up to then can still be answered with http.Error.
+type jsonList struct {
+	w       io.Writer
+	started bool
+	count   int
+}
+
+func newJSONList(w http.ResponseWriter) *jsonList {
+	return &jsonList{w: w}
+}
+
+func (l *jsonList) start() error {
+	if l.started {
+		return nil
+	}
+	l.started = true
+	_, err := io.WriteString(l.w, `{"data":[`)
+	return err
+}
+
+// Add writes one element of the data array.
+func (l *jsonList) Add(v interface{}) error {
+	if err := l.start(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if l.count > 0 {
+		b = append([]byte{','}, b...)
+	}
+	l.count++
+	_, err = l.w.Write(b)
+	return err
+}
+
+// Close ends the data array and writes the remaining top-level fields, sorted by key.
+func (l *jsonList) Close(fields map[string]interface{}) error {
+	if err := l.start(); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(l.w, "]"); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name, _ := json.Marshal(key)
+		value, err := json.Marshal(fields[key])
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(l.w, ","+string(name)+":"+string(value)); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(l.w, "}\n")
+	return err
+}
+
+// Fail logs err and, if the response has not started yet, answers with a 500. Once rows
+// have been written the status can no longer change, so the client gets truncated JSON.
+func (l *jsonList) Fail(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	loggerFrom(r.Context()).Error(msg, "err", err, "streamed", l.count)
+	if !l.started {
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// LevelSupply reports the supply of one token level.
+type LevelSupply struct {
+	TokenLevel    int     `json:"token_level"`
+	MaxSupply     int     `json:"max_supply"`
+	Minted        int     `json:"minted"`
+	Generated     int     `json:"generated"` // Token IDs present in token_info
+	Owned         int     `json:"owned"`     // Tokens with a known owner in current_owners
+	Unowned       int     `json:"unowned"`
+	PercentMined  float64 `json:"percent_mined"`
+	PercentOwned  float64 `json:"percent_owned"`
+	IsCurrentMint bool    `json:"is_current_mint"`
+}
+
+// SupplyStats is the response of /stats/supply and the payload of a daily snapshot.
+type SupplyStats struct {
+	TotalSupply  int           `json:"total_supply"`
+	TotalMinted  int           `json:"total_minted"`
+	Generated    int           `json:"generated"`
+	Owned        int           `json:"owned"`
+	Unowned      int           `json:"unowned"`
+	PercentMined float64       `json:"percent_mined"`
+	CurrentLevel int           `json:"current_level"`
+	CurrentNum   int           `json:"current_number"`
+	Levels       []LevelSupply `json:"levels"`
+}
+
+// SupplySnapshot is one daily entry of the supply history.
+type SupplySnapshot struct {
+	SnapshotDate string      `json:"snapshot_date"`
+	Supply       SupplyStats `json:"supply"`
+	CreatedAt    time.Time   `json:"created_at"`
+}
+
+// mintedPerLevel returns how many tokens of each TokenMap level have been minted when
+// the mint is at token currentNum of currentLevel. Earlier levels are fully minted.
+func mintedPerLevel(currentLevel, currentNum int) map[int]int {
+	minted := make(map[int]int, len(TokenMap))
+	for level, maxNum := range TokenMap {
+		switch {
+		case level < currentLevel:
+			minted[level] = maxNum
+		case level == currentLevel:
+			minted[level] = min(currentNum, maxNum)
+		default:
+			minted[level] = 0
+		}
+	}
+	return minted
+}
+
+func percentOf(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole) * 100
+}
+
+func computeSupplyStats() (*SupplyStats, error) {
+	currentLevel, currentNum := tokenNum()
+	minted := mintedPerLevel(currentLevel, currentNum)
+
+	rows, err := db.Query(`
+		SELECT ti.token_level, COUNT(*), COUNT(co.token_id)
+		FROM token_info ti
+		LEFT JOIN current_owners co ON co.token_id = ti.token_id
+		WHERE ti.token_type = 'RBT'
+		GROUP BY ti.token_level
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query token counts: %w", err)
+	}
+	defer rows.Close()
+
+	generated := make(map[int]int)
+	owned := make(map[int]int)
+	for rows.Next() {
+		var level, gen, own int
+		if err := rows.Scan(&level, &gen, &own); err != nil {
+			return nil, fmt.Errorf("failed to scan token counts: %w", err)
+		}
+		generated[level] = gen
+		owned[level] = own
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	levels := make([]int, 0, len(TokenMap))
+	for level := range TokenMap {
+		if level == 0 {
+			continue // Level 0 has no tokens
+		}
+		levels = append(levels, level)
+	}
+	sort.Ints(levels)
+
+	stats := &SupplyStats{CurrentLevel: currentLevel, CurrentNum: currentNum}
+	for _, level := range levels {
+		ls := LevelSupply{
+			TokenLevel:    level,
+			MaxSupply:     TokenMap[level],
+			Minted:        minted[level],
+			Generated:     generated[level],
+			Owned:         owned[level],
+			Unowned:       generated[level] - owned[level],
+			PercentMined:  percentOf(minted[level], TokenMap[level]),
+			PercentOwned:  percentOf(owned[level], generated[level]),
+			IsCurrentMint: level == currentLevel,
+		}
+		stats.TotalSupply += ls.MaxSupply
+		stats.TotalMinted += ls.Minted
+		stats.Generated += ls.Generated
+		stats.Owned += ls.Owned
+		stats.Levels = append(stats.Levels, ls)
+	}
+	stats.Unowned = stats.Generated - stats.Owned
+	stats.PercentMined = percentOf(stats.TotalMinted, stats.TotalSupply)
+
+	return stats, nil
+}
+
+// recordSupplySnapshot stores today's supply stats, replacing an earlier snapshot of the same day.
+func recordSupplySnapshot() error {
+	stats, err := computeSupplyStats()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("failed to encode supply snapshot: %w", err)
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO supply_snapshots (snapshot_date, total_minted, owned, supply)
+		VALUES (CURRENT_DATE, $1, $2, $3)
+		ON CONFLICT (snapshot_date) DO UPDATE
+		SET total_minted = EXCLUDED.total_minted, owned = EXCLUDED.owned,
+			supply = EXCLUDED.supply, created_at = NOW()
+	`, stats.TotalMinted, stats.Owned, payload)
+	if err != nil {
+		return fmt.Errorf("failed to store supply snapshot: %w", err)
+	}
+	return nil
+}
+
+func runSupplySnapshot(ctx context.Context) (JobResult, error) {
+	if err := recordSupplySnapshot(); err != nil {
+		return JobResult{Errors: 1}, err
+	}
+	loggerFrom(ctx).Info("Supply snapshot recorded")
+	return JobResult{Processed: 1}, nil
+}
+
+func getSupplyStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	stats, err := computeSupplyStats()
+	if err != nil {
+		http.Error(w, "Failed to compute supply stats", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Supply stats error", "err", err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
+
+func getSupplyHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	days := 30 // default
+	if val := r.URL.Query().Get("days"); val != "" {
+		if d, err := strconv.Atoi(val); err == nil && d > 0 {
+			if d > 366 {
+				d = 366
+			}
+			days = d
+		}
+	}
+
+	rows, err := db.Query(`
+		SELECT snapshot_date, supply, created_at
+		FROM supply_snapshots
+		WHERE snapshot_date > CURRENT_DATE - $1::int
+		ORDER BY snapshot_date
+	`, days)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Supply history query error", "err", err)
+		return
+	}
+	defer rows.Close()
+
+	history := []SupplySnapshot{}
+	for rows.Next() {
+		var s SupplySnapshot
+		var date time.Time
+		var payload []byte
+		if err := rows.Scan(&date, &payload, &s.CreatedAt); err != nil {
+			http.Error(w, "DB row scan error", http.StatusInternalServerError)
+			loggerFrom(r.Context()).Error("Row scan error", "err", err)
+			return
+		}
+		if err := json.Unmarshal(payload, &s.Supply); err != nil {
+			http.Error(w, "Corrupt supply snapshot", http.StatusInternalServerError)
+			loggerFrom(r.Context()).Error("Supply snapshot decode error", "err", err)
+			return
+		}
+		s.SnapshotDate = date.Format("2006-01-02")
+		history = append(history, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Rows iteration error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Rows iteration error", "err", err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"data": history}); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
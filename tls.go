@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Certificate files are checked for changes this often; SIGHUP reloads immediately.
+const certPollInterval = 30 * time.Second
+
+// CertReloader serves the TLS certificate and client CA pool from disk and swaps them in
+// when the files change. Only new handshakes see the new files, so open connections are
+// not dropped.
+type CertReloader struct {
+	c TLSConfig
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// NewCertReloader loads the configured files, failing if they cannot be used.
+func NewCertReloader(c TLSConfig) (*CertReloader, error) {
+	cr := &CertReloader{c: c}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// Reload reads the certificate, key and client CA again. On error the previous ones stay in use.
+func (cr *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.c.CertFile, cr.c.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if cr.c.ClientCAFile != "" {
+		pem, err := os.ReadFile(cr.c.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA %s", cr.c.ClientCAFile)
+		}
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.clientCA = pool
+	cr.modTimes = cr.statFiles()
+	cr.mu.Unlock()
+	return nil
+}
+
+func (cr *CertReloader) files() []string {
+	files := []string{cr.c.CertFile, cr.c.KeyFile}
+	if cr.c.ClientCAFile != "" {
+		files = append(files, cr.c.ClientCAFile)
+	}
+	return files
+}
+
+func (cr *CertReloader) statFiles() map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+	for _, file := range cr.files() {
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+func (cr *CertReloader) changed() bool {
+	current := cr.statFiles()
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	for file, modTime := range current {
+		if !modTime.Equal(cr.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reloads on SIGHUP and when the files change, until ctx is cancelled.
+func (cr *CertReloader) Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(certPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("SIGHUP received, reloading TLS certificate")
+		case <-ticker.C:
+			if !cr.changed() {
+				continue
+			}
+			slog.Info("TLS certificate files changed, reloading")
+		}
+
+		if err := cr.Reload(); err != nil {
+			slog.Error("TLS certificate reload failed, keeping the current one", "err", err)
+		} else {
+			slog.Info("TLS certificate reloaded")
+		}
+	}
+}
+
+// TLSConfig returns a server config that picks up reloaded files on every handshake.
+// With a client CA, certificates are requested but optional, so only the admin
+// endpoints need to insist on one.
+func (cr *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cr.getCertificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cr.mu.RLock()
+			pool := cr.clientCA
+			cr.mu.RUnlock()
+			if pool == nil {
+				return nil, nil // Use the base config
+			}
+
+			return &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: cr.getCertificate,
+				NextProtos:     []string{"h2", "http/1.1"},
+				ClientAuth:     tls.VerifyClientCertIfGiven,
+				ClientCAs:      pool,
+			}, nil
+		},
+	}
+}
+
+func (cr *CertReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// requireClientCert restricts a subrouter to clients that presented a certificate signed
+// by the configured client CA. Without a client CA it lets every request through, so it
+// must be combined with requireAuth.
+func requireClientCert(c TLSConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !c.Enabled || c.ClientCAFile == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				loggerFrom(r.Context()).Warn("Admin request without a client certificate")
+				http.Error(w, "Client certificate required", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL on the HTTPS listener.
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
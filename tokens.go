@@ -0,0 +1,190 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// TokenDetails aggregates everything the token page needs in a single response.
+type TokenDetails struct {
+	TokenInfo          TokenInfo     `json:"token_info"`
+	CurrentOwner       *CurrentOwner `json:"current_owner"`
+	LatestTransactions []Transaction `json:"latest_transactions"`
+	ParentToken        *TokenInfo    `json:"parent_token"`
+	ChildTokens        []TokenInfo   `json:"child_tokens"`
+	LastChecked        *time.Time    `json:"last_checked"`
+}
+
+// fetchTokenInfo loads a single token_info row. Returns sql.ErrNoRows if the token is unknown.
+// token_info rows never change once generated, so found tokens are cached.
+func fetchTokenInfo(tokenID string) (*TokenInfo, error) {
+	key := "token-info:" + tokenID
+	if cached, ok := responseCache.Get(key); ok {
+		t := cached.(TokenInfo)
+		return &t, nil
+	}
+
+	var t TokenInfo
+	var parentTokenID, tokenType sql.NullString
+
+	err := db.QueryRow(`
+		SELECT token_id, token_level, token_number, token_value, parent_token_id, token_type
+		FROM token_info
+		WHERE token_id = $1
+	`, tokenID).Scan(&t.TokenID, &t.TokenLevel, &t.TokenNumber, &t.TokenValue, &parentTokenID, &tokenType)
+	if err != nil {
+		return nil, err
+	}
+
+	t.ParentTokenID = parentTokenID.String
+	t.TokenType = tokenType.String
+	responseCache.Set(key, t)
+	return &t, nil
+}
+
+// fetchChildTokens returns the tokens whose parent_token_id is tokenID.
+func fetchChildTokens(tokenID string) ([]TokenInfo, error) {
+	rows, err := db.Query(`
+		SELECT token_id, token_level, token_number, token_value, token_type
+		FROM token_info
+		WHERE parent_token_id = $1
+		ORDER BY token_level, token_number
+	`, tokenID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query child tokens: %w", err)
+	}
+	defer rows.Close()
+
+	children := []TokenInfo{}
+	for rows.Next() {
+		var t TokenInfo
+		var tokenType sql.NullString
+		if err := rows.Scan(&t.TokenID, &t.TokenLevel, &t.TokenNumber, &t.TokenValue, &tokenType); err != nil {
+			return nil, fmt.Errorf("failed to scan child token: %w", err)
+		}
+		t.TokenType = tokenType.String
+		t.ParentTokenID = tokenID
+		children = append(children, t)
+	}
+	return children, rows.Err()
+}
+
+// fetchCurrentOwner loads the current_owners row for a token along with its last check time.
+// Returns sql.ErrNoRows if the token has never been seen pinned.
+func fetchCurrentOwner(tokenID string) (*CurrentOwner, *time.Time, error) {
+	var owner CurrentOwner
+	var lastChecked sql.NullTime
+
+	err := db.QueryRow(`
+		SELECT token_id, peer_ids, epoch, quorums, timestamp, last_checked
+		FROM current_owners
+		WHERE token_id = $1
+	`, tokenID).Scan(&owner.TokenID, pq.Array(&owner.PeerID), &owner.Epoch, pq.Array(&owner.Quorums), &owner.Timestamp, &lastChecked)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if lastChecked.Valid {
+		return &owner, &lastChecked.Time, nil
+	}
+	return &owner, &owner.Timestamp, nil
+}
+
+// fetchLatestTransactions returns the most recent transactions for a token, newest first.
+func fetchLatestTransactions(tokenID string, limit int) ([]Transaction, error) {
+	rows, err := db.Query(`
+		SELECT tx_id, token_id, peer_ids, epoch, quorums, timestamp
+		FROM transactions
+		WHERE token_id = $1
+		ORDER BY timestamp DESC
+		LIMIT $2
+	`, tokenID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %w", err)
+	}
+	defer rows.Close()
+
+	transactions := []Transaction{}
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(&t.TxID, &t.TokenID, pq.Array(&t.PeerID), &t.Epoch, pq.Array(&t.Quorums), &t.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %w", err)
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, rows.Err()
+}
+
+func getTokenDetails(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	tokenID := vars["tokenID"]
+
+	txLimit := 10 // default
+	if val := r.URL.Query().Get("tx_limit"); val != "" {
+		if l, err := strconv.Atoi(val); err == nil && l > 0 {
+			if l > 100 {
+				l = 100
+			}
+			txLimit = l
+		}
+	}
+
+	info, err := fetchTokenInfo(tokenID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Token info query error", "err", err)
+		return
+	}
+
+	details := TokenDetails{TokenInfo: *info}
+
+	owner, lastChecked, err := fetchCurrentOwner(tokenID)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Current owner query error", "err", err)
+		return
+	}
+	details.CurrentOwner = owner
+	details.LastChecked = lastChecked
+
+	details.LatestTransactions, err = fetchLatestTransactions(tokenID, txLimit)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Transactions query error", "err", err)
+		return
+	}
+
+	if info.ParentTokenID != "" {
+		parent, err := fetchTokenInfo(info.ParentTokenID)
+		if err != nil && err != sql.ErrNoRows {
+			http.Error(w, "DB query error", http.StatusInternalServerError)
+			loggerFrom(r.Context()).Error("Parent token query error", "err", err)
+			return
+		}
+		details.ParentToken = parent
+	}
+
+	details.ChildTokens, err = fetchChildTokens(tokenID)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Child tokens query error", "err", err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(details); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+)
+
+// A sync still pending after this long was abandoned by a replica that went away and no
+// longer blocks new requests for its token.
+const syncRequestTimeout = 10 * time.Minute
+
+// Finished syncs are kept this long for polling and reuse, then deleted.
+const syncRequestRetention = 24 * time.Hour
+
+// SyncRequest is one on-demand pin check of a token, polled through its job ID.
+type SyncRequest struct {
+	JobID      int64           `json:"job_id"`
+	TokenID    string          `json:"token_id"`
+	Status     string          `json:"status"`            // queued, running, succeeded or failed
+	Outcome    string          `json:"outcome,omitempty"` // changed, unchanged or unknown_token
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at"`
+}
+
+// rateLimitError is returned when a request is refused by a rate limit.
+type rateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %v", e.retryAfter)
+}
+
+// TokenSyncer runs on-demand syncs in the background with bounded concurrency. Requests for
+// a token that already has a pending sync share it instead of starting another DHT lookup.
+type TokenSyncer struct {
+	ctx       context.Context
+	sem       chan struct{}
+	global    *rate.Limiter
+	anonymous *rate.Limiter
+	clients   *ClientLimiters
+	wg        sync.WaitGroup
+}
+
+var syncer *TokenSyncer
+
+// NewTokenSyncer returns a syncer whose runs are cancelled with ctx.
+func NewTokenSyncer(ctx context.Context, c SyncConfig) *TokenSyncer {
+	concurrency := c.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &TokenSyncer{
+		ctx:       ctx,
+		sem:       make(chan struct{}, concurrency),
+		global:    newLimiter(c.GlobalLimit),
+		anonymous: newLimiter(c.AnonymousLimit),
+		clients:   NewClientLimiters(c.ClientLimit),
+	}
+}
+
+// Submit returns the pending sync of tokenID, or starts a new one. The client limit applies
+// to every request, the global limit only to requests that start a new sync. Anonymous
+// requests draw on their own global limit instead, so they cannot starve API clients.
+func (s *TokenSyncer) Submit(tokenID, client string, anonymous bool) (req *SyncRequest, coalesced bool, err error) {
+	if ok, retry := s.clients.Allow(client); !ok {
+		return nil, false, &rateLimitError{retryAfter: retry}
+	}
+
+	if err := expireAbandonedSyncs(); err != nil {
+		return nil, false, err
+	}
+
+	req, err = fetchPendingSync(tokenID)
+	if err == nil {
+		return req, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, err
+	}
+
+	global := s.global
+	if anonymous {
+		global = s.anonymous
+	}
+	if ok, retry := reserve(global); !ok {
+		return nil, false, &rateLimitError{retryAfter: retry}
+	}
+
+	req, err = insertSyncRequest(tokenID, client)
+	if err == sql.ErrNoRows {
+		// Another request for the token won the race, share its sync
+		req, err = fetchPendingSync(tokenID)
+		return req, true, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.wg.Add(1)
+	go s.run(req.JobID, tokenID, client)
+	return req, false, nil
+}
+
+// Wait blocks until all started syncs have finished.
+func (s *TokenSyncer) Wait() {
+	s.wg.Wait()
+}
+
+func (s *TokenSyncer) run(jobID int64, tokenID, client string) {
+	defer s.wg.Done()
+
+	ctx, cancel := context.WithTimeout(s.ctx, syncRequestTimeout)
+	defer cancel()
+	logger := loggerFrom(ctx).With("sync_job_id", jobID, "token_id", tokenID, "client", client)
+	ctx = withLogger(ctx, logger)
+
+	select {
+	case s.sem <- struct{}{}:
+		defer func() { <-s.sem }()
+	case <-ctx.Done():
+		s.finish(jobID, nil, ctx.Err())
+		return
+	}
+
+	if _, err := db.Exec(`UPDATE sync_requests SET status = 'running', started_at = NOW() WHERE request_id = $1`, jobID); err != nil {
+		logger.Error("Failed to mark sync running", "err", err)
+	}
+
+	info, err := checkPins(ctx, tokenID)
+	s.finish(jobID, info, err)
+	logger.Info("Token sync finished", "err", err)
+}
+
+// finish records the outcome of a sync. It runs after ctx may be cancelled, so it does not use it.
+func (s *TokenSyncer) finish(jobID int64, info *PinnerInfo, runErr error) {
+	status, outcome := "succeeded", "changed"
+	var result, errMsg sql.NullString
+	switch {
+	case runErr == nil && info != nil:
+		outcome = "unknown_token"
+		if b, err := json.Marshal(info); err == nil {
+			result = sql.NullString{String: string(b), Valid: true}
+		}
+	case errors.Is(runErr, errNoOwnershipChange):
+		outcome = "unchanged"
+	case runErr != nil:
+		status, outcome = "failed", ""
+		errMsg = sql.NullString{String: runErr.Error(), Valid: true}
+	}
+
+	_, err := db.Exec(`
+		UPDATE sync_requests
+		SET status = $1, outcome = NULLIF($2, ''), result = $3::jsonb, error = $4, finished_at = NOW()
+		WHERE request_id = $5
+	`, status, outcome, result, errMsg, jobID)
+	if err != nil {
+		loggerFrom(s.ctx).Error("Failed to record sync result", "sync_job_id", jobID, "err", err)
+	}
+}
+
+// lastSyncPrune is when expireAbandonedSyncs last deleted old syncs, as Unix seconds.
+var lastSyncPrune atomic.Int64
+
+// expireAbandonedSyncs fails syncs abandoned by a replica that went away and, at most once
+// a minute, deletes finished syncs past syncRequestRetention.
+func expireAbandonedSyncs() error {
+	_, err := db.Exec(`
+		UPDATE sync_requests
+		SET status = 'failed', error = 'abandoned', finished_at = NOW()
+		WHERE status IN ('queued', 'running') AND created_at < NOW() - $1 * INTERVAL '1 second'
+	`, syncRequestTimeout.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to expire abandoned syncs: %w", err)
+	}
+
+	now := time.Now().Unix()
+	if last := lastSyncPrune.Load(); now-last < 60 || !lastSyncPrune.CompareAndSwap(last, now) {
+		return nil
+	}
+	_, err = db.Exec(`
+		DELETE FROM sync_requests
+		WHERE finished_at < NOW() - $1 * INTERVAL '1 second'
+	`, syncRequestRetention.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to delete old syncs: %w", err)
+	}
+	return nil
+}
+
+// insertSyncRequest returns sql.ErrNoRows when the token already has a pending sync.
+func insertSyncRequest(tokenID, client string) (*SyncRequest, error) {
+	row := db.QueryRow(`
+		INSERT INTO sync_requests (token_id, client, status)
+		VALUES ($1, $2, 'queued')
+		ON CONFLICT (token_id) WHERE status IN ('queued', 'running') DO NOTHING
+		RETURNING `+syncRequestColumns, tokenID, client)
+	return scanSyncRequest(row.Scan)
+}
+
+func fetchPendingSync(tokenID string) (*SyncRequest, error) {
+	row := db.QueryRow(`
+		SELECT `+syncRequestColumns+`
+		FROM sync_requests
+		WHERE token_id = $1 AND status IN ('queued', 'running')
+	`, tokenID)
+	return scanSyncRequest(row.Scan)
+}
+
+// fetchRecentSync returns the newest sync of tokenID that succeeded within syncRequestTimeout.
+func fetchRecentSync(tokenID string) (*SyncRequest, error) {
+	row := db.QueryRow(`
+		SELECT `+syncRequestColumns+`
+		FROM sync_requests
+		WHERE token_id = $1 AND status = 'succeeded' AND finished_at > NOW() - $2 * INTERVAL '1 second'
+		ORDER BY finished_at DESC
+		LIMIT 1
+	`, tokenID, syncRequestTimeout.Seconds())
+	return scanSyncRequest(row.Scan)
+}
+
+func fetchSyncRequest(jobID int64) (*SyncRequest, error) {
+	row := db.QueryRow(`SELECT `+syncRequestColumns+` FROM sync_requests WHERE request_id = $1`, jobID)
+	return scanSyncRequest(row.Scan)
+}
+
+const syncRequestColumns = `request_id, token_id, status, outcome, result, error, created_at, started_at, finished_at`
+
+func scanSyncRequest(scan func(dest ...interface{}) error) (*SyncRequest, error) {
+	var req SyncRequest
+	var outcome, result, errMsg sql.NullString
+	var startedAt, finishedAt sql.NullTime
+	err := scan(&req.JobID, &req.TokenID, &req.Status, &outcome, &result, &errMsg, &req.CreatedAt, &startedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	req.Outcome = outcome.String
+	req.Error = errMsg.String
+	if result.Valid {
+		req.Result = json.RawMessage(result.String)
+	}
+	if startedAt.Valid {
+		req.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		req.FinishedAt = &finishedAt.Time
+	}
+	return &req, nil
+}
+
+// syncLatestTokenState queues a pin check of the token and answers 202 with a job ID that
+// can be polled at /synctokenstate/jobs/{jobID}.
+func syncLatestTokenState(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tokenID := vars["tokenID"]
+
+	req, coalesced, err := syncer.Submit(tokenID, clientFrom(r.Context()), false)
+	var limitErr *rateLimitError
+	if errors.As(err, &limitErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(limitErr.retryAfter)))
+		http.Error(w, "Too many sync requests", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Sync request failed", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Sync request error", "err", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/synctokenstate/jobs/%d", req.JobID))
+	w.WriteHeader(http.StatusAccepted)
+
+	response := map[string]interface{}{
+		"data":      req,
+		"coalesced": coalesced,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
+
+// getUnknownTokenPinners answers /token-updates for a token missing from token_info. It
+// serves the pinners found by a recent sync, or starts one through the syncer and answers
+// 202 so the client retries, keeping anonymous requests from triggering DHT lookups directly.
+func getUnknownTokenPinners(w http.ResponseWriter, r *http.Request, tokenID string) {
+	req, err := fetchRecentSync(tokenID)
+	if err == nil {
+		var info PinnerInfo
+		if req.Outcome != "unknown_token" || json.Unmarshal(req.Result, &info) != nil {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"tokenDetails":       info.TokenDetails,
+			"currentPinner":      info.CurrentPinner,
+			"currentEpochPinner": info.CurrentEpochPinner,
+			"isExists":           false,
+		})
+		return
+	}
+	if err != sql.ErrNoRows {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Sync query error", "err", err)
+		return
+	}
+
+	client, anonymous := requestClient(r)
+	req, _, err = syncer.Submit(tokenID, client, anonymous)
+	var limitErr *rateLimitError
+	if errors.As(err, &limitErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(limitErr.retryAfter)))
+		http.Error(w, "Too many sync requests", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Sync request failed", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Sync request error", "err", err)
+		return
+	}
+
+	w.Header().Set("Retry-After", "5")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": req, "isExists": false})
+}
+
+func getSyncJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	jobID, err := strconv.ParseInt(vars["jobID"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+
+	req, err := fetchSyncRequest(jobID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Sync job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Sync job query error", "err", err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"data": req}); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("JSON encoding error", "err", err)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Transfer is an explicit ownership change of a token between two consecutive
+// transactions rows. FromPeerIDs is empty for the first sighting of a token.
+type Transfer struct {
+	TransferID  int       `json:"transfer_id"`
+	TxID        int       `json:"tx_id"`
+	TokenID     string    `json:"token_id"`
+	FromPeerIDs []string  `json:"from_peer_ids"`
+	ToPeerIDs   []string  `json:"to_peer_ids"`
+	Epoch       int       `json:"epoch"`
+	DetectedAt  time.Time `json:"detected_at"`
+	Direction   string    `json:"direction,omitempty"` // "received" or "sent", set for peer queries
+}
+
+// insertTransfer records the transfer produced by transaction txID inside an open DB transaction.
+func insertTransfer(tx *sql.Tx, txID int, t Transaction, fromPeerIDs []string) error {
+	_, err := tx.Exec(`
+		INSERT INTO transfers (tx_id, token_id, from_peer_ids, to_peer_ids, epoch, detected_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (tx_id) DO NOTHING
+	`, txID, t.TokenID, pq.Array(fromPeerIDs), pq.Array(t.PeerID), t.Epoch, t.Timestamp)
+	if err != nil {
+		return fmt.Errorf("failed to insert transfer: %w", err)
+	}
+	return nil
+}
+
+// backfillTransfers derives transfers for transactions rows that predate the transfers
+// table or were loaded from a snapshot. It is idempotent and safe to run on every startup;
+// the scan is skipped when no transactions were added since the last one.
+func backfillTransfers() error {
+	var latest, scanned sql.NullInt64
+	err := db.QueryRow(`
+		SELECT (SELECT MAX(tx_id) FROM transactions), (SELECT max_tx_id FROM transfers_backfill)
+	`).Scan(&latest, &scanned)
+	if err != nil {
+		return fmt.Errorf("failed to check transfers backfill: %w", err)
+	}
+	if !latest.Valid || scanned.Valid && scanned.Int64 >= latest.Int64 {
+		return nil
+	}
+
+	res, err := db.Exec(`
+		WITH transitions AS (
+			SELECT tx_id, token_id, peer_ids, epoch, timestamp,
+				LAG(peer_ids) OVER (PARTITION BY token_id ORDER BY timestamp, tx_id) AS prev_peer_ids
+			FROM transactions
+		)
+		INSERT INTO transfers (tx_id, token_id, from_peer_ids, to_peer_ids, epoch, detected_at)
+		SELECT tx_id, token_id, COALESCE(prev_peer_ids, '{}'), peer_ids, epoch, timestamp
+		FROM transitions t
+		WHERE prev_peer_ids IS DISTINCT FROM peer_ids
+			AND NOT EXISTS (SELECT 1 FROM transfers tr WHERE tr.tx_id = t.tx_id)
+		ON CONFLICT (tx_id) DO NOTHING
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to backfill transfers: %w", err)
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected > 0 {
+		slog.Info("Backfilled transfers from transactions", "count", rowsAffected)
+	}
+
+	// Rows added after latest was read record their transfers in upsertTransaction
+	_, err = db.Exec(`
+		INSERT INTO transfers_backfill (id, max_tx_id) VALUES (TRUE, $1)
+		ON CONFLICT (id) DO UPDATE SET max_tx_id = GREATEST(transfers_backfill.max_tx_id, EXCLUDED.max_tx_id)
+	`, latest.Int64)
+	if err != nil {
+		return fmt.Errorf("failed to record transfers backfill: %w", err)
+	}
+	return nil
+}
+
+// scanTransfers calls fn for each scanned row.
+func scanTransfers(rows *sql.Rows, withDirection bool, fn func(Transfer) error) error {
+	for rows.Next() {
+		var t Transfer
+		dest := []interface{}{&t.TransferID, &t.TxID, &t.TokenID, pq.Array(&t.FromPeerIDs), pq.Array(&t.ToPeerIDs), &t.Epoch, &t.DetectedAt}
+		if withDirection {
+			dest = append(dest, &t.Direction)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("failed to scan transfer: %w", err)
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// peerTransfersFilter matches transfers where the peer ($1) gained or lost ownership.
+const peerTransfersFilter = `
+	(to_peer_ids @> ARRAY[$1]::text[] OR from_peer_ids @> ARRAY[$1]::text[])
+	AND (to_peer_ids @> ARRAY[$1]::text[]) <> (from_peer_ids @> ARRAY[$1]::text[])
+`
+
+func countPeerTransfers(peerID string) (int, error) {
+	var total int
+	err := db.QueryRow(`SELECT COUNT(*) FROM transfers WHERE `+peerTransfersFilter, peerID).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count peer transfers: %w", err)
+	}
+	return total, nil
+}
+
+// fetchPeerTransfers calls fn for each transfer of one page, newest first.
+func fetchPeerTransfers(peerID string, limit, offset int, fn func(Transfer) error) error {
+
+	rows, err := db.Query(`
+		SELECT transfer_id, tx_id, token_id, from_peer_ids, to_peer_ids, epoch, detected_at,
+			CASE WHEN to_peer_ids @> ARRAY[$1]::text[] THEN 'received' ELSE 'sent' END
+		FROM transfers
+		WHERE `+peerTransfersFilter+`
+		ORDER BY detected_at DESC, transfer_id DESC
+		LIMIT $2 OFFSET $3
+	`, peerID, limit, offset)
+	if err != nil {
+		return fmt.Errorf("failed to query peer transfers: %w", err)
+	}
+	defer rows.Close()
+
+	return scanTransfers(rows, true, fn)
+}
+
+func countTokenTransfers(tokenID string) (int, error) {
+	var total int
+	err := db.QueryRow(`SELECT COUNT(*) FROM transfers WHERE token_id = $1`, tokenID).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count token transfers: %w", err)
+	}
+	return total, nil
+}
+
+// fetchTokenTransfers calls fn for each transfer of one page, newest first.
+func fetchTokenTransfers(tokenID string, limit, offset int, fn func(Transfer) error) error {
+
+	rows, err := db.Query(`
+		SELECT transfer_id, tx_id, token_id, from_peer_ids, to_peer_ids, epoch, detected_at
+		FROM transfers
+		WHERE token_id = $1
+		ORDER BY detected_at DESC, transfer_id DESC
+		LIMIT $2 OFFSET $3
+	`, tokenID, limit, offset)
+	if err != nil {
+		return fmt.Errorf("failed to query token transfers: %w", err)
+	}
+	defer rows.Close()
+
+	return scanTransfers(rows, false, fn)
+}
+
+func getPeerTransfers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	peerID := vars["peerID"]
+
+	page, limit, offset := parsePagination(r, 30)
+
+	total, err := countPeerTransfers(peerID)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Peer transfers query error", "err", err)
+		return
+	}
+
+	if total == 0 {
+		http.Error(w, "No transfers found for peerID "+peerID, http.StatusNotFound)
+		return
+	}
+
+	list := newJSONList(w)
+	if err := fetchPeerTransfers(peerID, limit, offset, func(t Transfer) error { return list.Add(t) }); err != nil {
+		list.Fail(w, r, "Peer transfers query error", err)
+		return
+	}
+	if err := list.Close(map[string]interface{}{"pagination": paginationMeta(total, page, limit)}); err != nil {
+		list.Fail(w, r, "JSON encoding error", err)
+	}
+}
+
+func getTokenTransfers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	tokenID := vars["tokenID"]
+
+	page, limit, offset := parsePagination(r, 30)
+
+	if _, err := fetchTokenInfo(tokenID); err == sql.ErrNoRows {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Token info query error", "err", err)
+		return
+	}
+
+	total, err := countTokenTransfers(tokenID)
+	if err != nil {
+		http.Error(w, "DB query error", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("Token transfers query error", "err", err)
+		return
+	}
+
+	list := newJSONList(w)
+	if err := fetchTokenTransfers(tokenID, limit, offset, func(t Transfer) error { return list.Add(t) }); err != nil {
+		list.Fail(w, r, "Token transfers query error", err)
+		return
+	}
+	if err := list.Close(map[string]interface{}{"pagination": paginationMeta(total, page, limit)}); err != nil {
+		list.Fail(w, r, "JSON encoding error", err)
+	}
+}
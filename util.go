@@ -1,47 +1,65 @@
 package main
 
 import (
-	"log"
+	"context"
+	"math"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 )
 
-func startWeeklySync() {
-	ticker := time.NewTicker(7 * 24 * time.Hour) // 1 week
-	defer ticker.Stop()
+// runWeeklySync generates newly minted token IDs and checks pins for tokens that
+// have no current owner yet.
+func runWeeklySync(ctx context.Context) (JobResult, error) {
+	logger := loggerFrom(ctx)
+	logger.Info("Weekly sync started")
 
-	for {
-		log.Println("Weekly sync started...")
+	checkTokenCount(ctx)
 
-		checkTokenCount()
-
-		if err := syncMissingCurrentOwners(); err != nil {
-			log.Println("Weekly sync error:", err)
-		} else {
-			log.Println("Weekly sync completed successfully.")
-		}
-
-		<-ticker.C
+	if err := syncMissingCurrentOwners(ctx); err != nil {
+		return JobResult{Errors: 1}, err
 	}
+
+	logger.Info("Weekly sync completed")
+	return JobResult{}, nil
 }
 
 // Rubix Week Epoch starting reference date is Jan 01 2025.
 var RubixWeekEpochStartDate = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
 
+// Length of one Rubix week epoch.
+const EpochDuration = 7 * 24 * time.Hour
+
 // To calculate the week number that's going on since reference date for a transaction
 func GetWeeksPassed() int {
-	now := time.Now().UTC()
-	duration := now.Sub(RubixWeekEpochStartDate)
-	// Handle case where current time is before ReferenceDate
+	return EpochAt(time.Now())
+}
+
+// EpochAt returns the week epoch containing t. Epoch 1 starts at RubixWeekEpochStartDate;
+// times before the start date belong to epoch 0.
+func EpochAt(t time.Time) int {
+	duration := t.UTC().Sub(RubixWeekEpochStartDate)
+	// Handle case where t is before ReferenceDate
 	if duration < 0 {
-		return 0 // If the current time is before the start date, return 0 intervals
+		return 0
 	}
-	weeksPassed := int(duration.Hours() / (24 * 7))
+	weeksPassed := int(duration / EpochDuration)
 	// Add +1 to ensure the first week starts as week 1
 	return weeksPassed + 1
 }
 
+// EpochBounds returns the start (inclusive) and end (exclusive) of epoch n. Epoch 0 has
+// no bounds, so ok is false for n < 1.
+func EpochBounds(n int) (start, end time.Time, ok bool) {
+	if n < 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	start = RubixWeekEpochStartDate.Add(time.Duration(n-1) * EpochDuration)
+	return start, start.Add(EpochDuration), true
+}
+
 func getAppDir() (string, error) {
 	// Use executable directory
 	exe, err := os.Executable()
@@ -50,3 +68,38 @@ func getAppDir() (string, error) {
 	}
 	return filepath.Dir(exe), nil
 }
+
+// parsePagination reads the page and limit query parameters, falling back to
+// page 1 and defaultLimit when they are missing or invalid. The limit is capped at
+// the configured server.max_page_size.
+func parsePagination(r *http.Request, defaultLimit int) (page, limit, offset int) {
+	page = 1
+	limit = defaultLimit
+
+	queryParams := r.URL.Query()
+	if val := queryParams.Get("page"); val != "" {
+		if p, err := strconv.Atoi(val); err == nil && p > 0 {
+			page = p
+		}
+	}
+	if val := queryParams.Get("limit"); val != "" {
+		if l, err := strconv.Atoi(val); err == nil && l > 0 {
+			limit = l
+		}
+	}
+	if limit > cfg.Server.MaxPageSize {
+		limit = cfg.Server.MaxPageSize
+	}
+
+	return page, limit, (page - 1) * limit
+}
+
+// paginationMeta builds the pagination block shared by list responses.
+func paginationMeta(total, page, limit int) map[string]interface{} {
+	return map[string]interface{}{
+		"total":        total,
+		"current_page": page,
+		"per_page":     limit,
+		"total_pages":  int(math.Ceil(float64(total) / float64(limit))),
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEpochAt(t *testing.T) {
+	start := RubixWeekEpochStartDate
+	week := EpochDuration
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want int
+	}{
+		{"at epoch start", start, 1},
+		{"just before epoch start", start.Add(-time.Nanosecond), 0},
+		{"just after epoch start", start.Add(time.Nanosecond), 1},
+		{"a year before epoch start", start.AddDate(-1, 0, 0), 0},
+		{"just before end of epoch 1", start.Add(week - time.Nanosecond), 1},
+		{"at start of epoch 2", start.Add(week), 2},
+		{"just after start of epoch 2", start.Add(week + time.Nanosecond), 2},
+		{"just before start of epoch 53", start.Add(52*week - time.Nanosecond), 52},
+		{"at start of epoch 53", start.Add(52 * week), 53},
+		{"non-UTC location", start.Add(week).In(time.FixedZone("UTC+5:30", 5*3600+1800)), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EpochAt(tt.t); got != tt.want {
+				t.Errorf("EpochAt(%v) = %d, want %d", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEpochBounds(t *testing.T) {
+	tests := []struct {
+		n         int
+		wantStart time.Time
+		wantOK    bool
+	}{
+		{-1, time.Time{}, false},
+		{0, time.Time{}, false},
+		{1, RubixWeekEpochStartDate, true},
+		{2, time.Date(2025, time.January, 8, 0, 0, 0, 0, time.UTC), true},
+		{53, time.Date(2025, time.December, 31, 0, 0, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		start, end, ok := EpochBounds(tt.n)
+		if ok != tt.wantOK || !start.Equal(tt.wantStart) {
+			t.Errorf("EpochBounds(%d) = %v, %v, want %v, %v", tt.n, start, ok, tt.wantStart, tt.wantOK)
+			continue
+		}
+		if ok && end.Sub(start) != EpochDuration {
+			t.Errorf("EpochBounds(%d) spans %v, want %v", tt.n, end.Sub(start), EpochDuration)
+		}
+	}
+}
+
+func TestEpochBoundsRoundTrip(t *testing.T) {
+	for n := 1; n <= 520; n++ {
+		start, end, ok := EpochBounds(n)
+		if !ok {
+			t.Fatalf("EpochBounds(%d) not ok", n)
+		}
+		if got := EpochAt(start); got != n {
+			t.Errorf("EpochAt(start of %d) = %d", n, got)
+		}
+		if got := EpochAt(end.Add(-time.Nanosecond)); got != n {
+			t.Errorf("EpochAt(end of %d - 1ns) = %d", n, got)
+		}
+		if got := EpochAt(end); got != n+1 {
+			t.Errorf("EpochAt(end of %d) = %d, want %d", n, got, n+1)
+		}
+	}
+}